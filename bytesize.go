@@ -0,0 +1,49 @@
+package expandenv
+
+import (
+	"math/big"
+	"regexp"
+)
+
+var byteSizeRegex = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)([kKMGTPE]i?)?B?$`)
+
+var byteSizeUnits = map[string]int64{
+	"":   1,
+	"k":  1e3,
+	"K":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+// parseByteSize parses sizes like 512Mi or 1G into a number of bytes. SI
+// suffixes are powers of 1000, binary ones like Ki powers of 1024.
+func parseByteSize(value string) (int64, bool) {
+	m := byteSizeRegex.FindStringSubmatch(value)
+	if m == nil {
+		return 0, false
+	}
+	unit, ok := byteSizeUnits[m[2]]
+	if !ok {
+		return 0, false
+	}
+	// The size is computed exactly, as a float would reject sizes like
+	// 1.1P whose product is not an integer due to rounding.
+	size, ok := new(big.Rat).SetString(m[1])
+	if !ok {
+		return 0, false
+	}
+	size.Mul(size, new(big.Rat).SetInt64(unit))
+	if !size.IsInt() || !size.Num().IsInt64() {
+		return 0, false
+	}
+	return size.Num().Int64(), true
+}
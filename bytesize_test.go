@@ -0,0 +1,53 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandByteSize(t *testing.T) {
+	testCases := []struct {
+		input  string
+		format string
+		output interface{}
+		error  error
+	}{
+		{input: "512", output: int64(512)},
+		{input: "1k", output: int64(1000)},
+		{input: "1K", output: int64(1000)},
+		{input: "1Ki", output: int64(1024)},
+		{input: "512Mi", output: int64(512 * 1024 * 1024)},
+		{input: "1G", output: int64(1000000000)},
+		{input: "1GB", output: int64(1000000000)},
+		{input: "1.5Gi", output: int64(1610612736)},
+		{input: "2Ti", output: int64(2 << 40)},
+		{input: "1.1P", output: int64(1100000000000000)},
+		{input: "1.12P", output: int64(1120000000000000)},
+		{input: "2.20P", output: int64(2200000000000000)},
+		{input: "0.07P", output: int64(70000000000000)},
+		{input: "9.223372036854775807E", output: int64(9223372036854775807)},
+		{input: "9.223372036854775808E", error: fmt.Errorf("9.223372036854775808E is not a valid byte size")},
+		{input: "10Gi", output: int64(4 << 30), format: "bytesize:clamp=0..4294967296"},
+		{input: "1.5", error: fmt.Errorf("1.5 is not a valid byte size")},
+		{input: "12X", error: fmt.Errorf("12X is not a valid byte size")},
+		{input: "-1M", error: fmt.Errorf("-1M is not a valid byte size")},
+		{input: "16Ei", error: fmt.Errorf("16Ei is not a valid byte size")},
+		{input: "Mi", error: fmt.Errorf("Mi is not a valid byte size")},
+	}
+
+	for _, testCase := range testCases {
+		format := testCase.format
+		if format == "" {
+			format = "bytesize"
+		}
+		output, err := ExpandMap("${SIZE:"+format+"}", map[string]string{"SIZE": testCase.input})
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.input)
+			assert.Equal(t, testCase.output, output, testCase.input)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.input)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package expandenv
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExpandCSV reads CSV records from r, expands every field and writes the
+// records to w. The input is copied as it was read, including quoting,
+// escaping and line endings, only fields that change are rewritten. Those
+// stay quoted if they were quoted, otherwise they are quoted only if needed,
+// so expanded values may contain commas, quotes or newlines. All fields are
+// strings, so formats that produce other types like ${PORT:number} are
+// rejected. Fields that failed are written as they were read.
+func ExpandCSV(r io.Reader, w io.Writer, values VariableLookup) error {
+	scanner := &csvScanner{reader: bufio.NewReader(r), line: 1}
+	writer := bufio.NewWriter(w)
+	errs := []error{}
+	row, column := 0, 0
+	for {
+		field, err := scanner.readField()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		output := field.raw
+		if hasPlaceholders(field.value) {
+			expanded, err := expandString(field.value, values)
+			if err != nil {
+				errs = append(errs, atPath(fmt.Sprintf("[%d][%d]", row, column), err))
+			} else if expanded != field.value {
+				output = quoteCSVField(expanded, field.quoted)
+			}
+		}
+		if _, err := writer.WriteString(output + field.end); err != nil {
+			return err
+		}
+		if field.end == "," {
+			column++
+			continue
+		}
+		// Like encoding/csv, blank lines do not count as records.
+		if column > 0 || field.raw != "" {
+			row++
+		}
+		column = 0
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return joinErrors(errs)
+}
+
+// csvField is a single field as read from the input. raw holds the field
+// as written, value its content with quotes removed and end the comma or
+// line ending that follows it.
+type csvField struct {
+	raw    string
+	value  string
+	quoted bool
+	end    string
+}
+
+// csvScanner reads raw CSV input and tracks the position for errors.
+type csvScanner struct {
+	reader *bufio.Reader
+	line   int
+	column int
+}
+
+func (s *csvScanner) readByte() (byte, error) {
+	b, err := s.reader.ReadByte()
+	if err == nil && b == '\n' {
+		s.line++
+		s.column = 0
+	} else if err == nil {
+		s.column++
+	}
+	return b, err
+}
+
+// next consumes the following byte if it equals b.
+func (s *csvScanner) next(b byte) bool {
+	if peek, err := s.reader.Peek(1); err != nil || peek[0] != b {
+		return false
+	}
+	_, _ = s.readByte()
+	return true
+}
+
+func (s *csvScanner) error(startLine int, err error) error {
+	return &csv.ParseError{StartLine: startLine, Line: s.line, Column: s.column, Err: err}
+}
+
+// readField reads the next field, following the rules of encoding/csv. It
+// returns io.EOF if the input is exhausted.
+func (s *csvScanner) readField() (csvField, error) {
+	field := csvField{}
+	raw := strings.Builder{}
+	value := strings.Builder{}
+	startLine := s.line
+	b, err := s.readByte()
+	if err != nil {
+		return field, err
+	}
+	if b == '"' {
+		field.quoted = true
+		raw.WriteByte(b)
+		for {
+			b, err := s.readByte()
+			if err == io.EOF {
+				return field, s.error(startLine, csv.ErrQuote)
+			}
+			if err != nil {
+				return field, err
+			}
+			raw.WriteByte(b)
+			if b != '"' {
+				value.WriteByte(b)
+				continue
+			}
+			if s.next('"') {
+				raw.WriteByte('"')
+				value.WriteByte('"')
+				continue
+			}
+			break
+		}
+		b, err = s.readByte()
+	}
+	for ; err == nil; b, err = s.readByte() {
+		if b == ',' || b == '\n' {
+			field.end = string(b)
+			break
+		}
+		if b == '\r' && s.next('\n') {
+			field.end = "\r\n"
+			break
+		}
+		if field.quoted {
+			return field, s.error(startLine, csv.ErrQuote)
+		}
+		if b == '"' {
+			return field, s.error(startLine, csv.ErrBareQuote)
+		}
+		raw.WriteByte(b)
+		value.WriteByte(b)
+	}
+	if err != nil && err != io.EOF {
+		return field, err
+	}
+	field.raw = raw.String()
+	field.value = value.String()
+	return field, nil
+}
+
+// quoteCSVField encodes value as a field, quoting it if the original field
+// was quoted or if the value needs it.
+func quoteCSVField(value string, quoted bool) string {
+	if !quoted && !strings.ContainsAny(value, ",\"\r\n") && !strings.HasPrefix(value, " ") && !strings.HasPrefix(value, "\t") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
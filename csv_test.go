@@ -0,0 +1,46 @@
+package expandenv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandCSV(t *testing.T) {
+	values := map[string]string{
+		"CSV_NAME":    "Doe, Jane",
+		"CSV_QUOTE":   `say "hi"`,
+		"CSV_CITY":    "Berlin",
+		"CSV_PORT":    "8080",
+		"CSV_MULTI":   "line1\nline2",
+		"CSV_COMPANY": "ACME",
+	}
+
+	output := bytes.Buffer{}
+	err := ExpandCSV(strings.NewReader("name,greeting,city\n\"${CSV_NAME}\",\"${CSV_QUOTE}, ${CSV_CITY}\",${CSV_CITY}\n\"a, ${CSV_COMPANY}\",${CSV_MULTI},static\n"), &output, lookupMap(values))
+	assert.NoError(t, err)
+	assert.Equal(t, "name,greeting,city\n\"Doe, Jane\",\"say \"\"hi\"\", Berlin\",Berlin\n\"a, ACME\",\"line1\nline2\",static\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandCSV(strings.NewReader("port,host\n${CSV_PORT:number},${CSV_UNKNOWN}\n${CSV_PORT},x\n"), &output, lookupMap(values))
+	assert.EqualError(t, err, "at [1][0]: ${CSV_PORT:number} does not expand to a string, at [1][1]: variable CSV_UNKNOWN is missing")
+	assert.Equal(t, "port,host\n${CSV_PORT:number},${CSV_UNKNOWN}\n8080,x\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandCSV(strings.NewReader("\"static\",\"${CSV_CITY}\",\"a \"\"b\"\"\"\r\n\n ${CSV_CITY},${CSV_NAME},x\r\n"), &output, lookupMap(values))
+	assert.NoError(t, err)
+	assert.Equal(t, "\"static\",\"Berlin\",\"a \"\"b\"\"\"\r\n\n\" Berlin\",\"Doe, Jane\",x\r\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandCSV(strings.NewReader("a,b\n\n${CSV_UNKNOWN}"), &output, lookupMap(values))
+	assert.EqualError(t, err, "at [1][0]: variable CSV_UNKNOWN is missing")
+	assert.Equal(t, "a,b\n\n${CSV_UNKNOWN}", output.String())
+
+	err = ExpandCSV(strings.NewReader("\"unterminated\n"), &bytes.Buffer{}, lookupMap(values))
+	assert.EqualError(t, err, "record on line 1; parse error on line 2, column 0: extraneous or missing \" in quoted-field")
+
+	err = ExpandCSV(strings.NewReader("a,b\"c\n"), &bytes.Buffer{}, lookupMap(values))
+	assert.EqualError(t, err, "parse error on line 1, column 4: bare \" in non-quoted-field")
+}
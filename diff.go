@@ -0,0 +1,66 @@
+package expandenv
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Change is a value of the expanded document that differs between two sets
+// of values.
+type Change struct {
+	Path string
+	Old  interface{}
+	New  interface{}
+}
+
+// sensitiveLeaf marks a leaf that was expanded from a placeholder with the
+// secret marker.
+type sensitiveLeaf struct {
+	value interface{}
+}
+
+// Diff expands input once with oldValues and once with newValues and
+// reports every expanded value that differs, sorted by path with array
+// indices in numeric order. This shows the effect of changing a variable
+// without comparing whole documents. Values expanded from a placeholder
+// marked as secret are reported as ***.
+func Diff(input interface{}, oldValues VariableLookup, newValues VariableLookup) ([]Change, error) {
+	oldLeaves := map[string]interface{}{}
+	if _, err := ExpandWithOptions(input, oldValues, Options{leaves: oldLeaves}); err != nil {
+		return nil, err
+	}
+	newLeaves := map[string]interface{}{}
+	if _, err := ExpandWithOptions(input, newValues, Options{leaves: newLeaves}); err != nil {
+		return nil, err
+	}
+	paths := map[string]bool{}
+	for path := range oldLeaves {
+		paths[path] = true
+	}
+	for path := range newLeaves {
+		paths[path] = true
+	}
+	changes := []Change{}
+	for path := range paths {
+		old, oldSensitive := unwrapLeaf(oldLeaves[path])
+		updated, newSensitive := unwrapLeaf(newLeaves[path])
+		if reflect.DeepEqual(old, updated) {
+			continue
+		}
+		if oldSensitive || newSensitive {
+			old, updated = "***", "***"
+		}
+		changes = append(changes, Change{Path: path, Old: old, New: updated})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return pathLess(changes[i].Path, changes[j].Path)
+	})
+	return changes, nil
+}
+
+func unwrapLeaf(leaf interface{}) (interface{}, bool) {
+	if leaf, ok := leaf.(sensitiveLeaf); ok {
+		return leaf.value, true
+	}
+	return leaf, false
+}
@@ -0,0 +1,74 @@
+package expandenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	input := map[string]interface{}{
+		"db": map[string]interface{}{
+			"host":     "${DIFF_HOST}",
+			"port":     "${DIFF_PORT:number}",
+			"url":      "postgres://${DIFF_HOST}:${DIFF_PORT}/app",
+			"password": "${DIFF_PASSWORD}",
+		},
+		"replicas": []interface{}{"${DIFF_HOST}", "static"},
+		"name":     "app",
+	}
+	oldValues := map[string]string{
+		"DIFF_HOST":     "db.local",
+		"DIFF_PORT":     "5432",
+		"DIFF_PASSWORD": "old",
+	}
+	newValues := map[string]string{
+		"DIFF_HOST":     "db.local",
+		"DIFF_PORT":     "6432",
+		"DIFF_PASSWORD": "old",
+	}
+
+	changes, err := Diff(input, lookupMap(oldValues), lookupMap(newValues))
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Path: "db.port", Old: 5432, New: 6432},
+		{Path: "db.url", Old: "postgres://db.local:5432/app", New: "postgres://db.local:6432/app"},
+	}, changes)
+
+	changes, err = Diff(input, lookupMap(oldValues), lookupMap(oldValues))
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{}, changes)
+
+	_, err = Diff(input, lookupMap(oldValues), lookupMap(map[string]string{"DIFF_HOST": "x", "DIFF_PORT": "1"}))
+	assert.EqualError(t, err, "at db.password: variable DIFF_PASSWORD is missing")
+
+	type host string
+	port := "${DIFF_PORT}"
+	newValues["DIFF_PASSWORD"] = "new"
+	changes, err = Diff(map[string]interface{}{
+		"hosts":    []host{"${DIFF_PORT}", "static"},
+		"port":     &port,
+		"password": "${DIFF_PASSWORD:secret}",
+		"dsn":      "user:${DIFF_PASSWORD:secret}@${DIFF_HOST}",
+		"same":     "${DIFF_HOST:secret}",
+	}, lookupMap(oldValues), lookupMap(newValues))
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Path: "dsn", Old: "***", New: "***"},
+		{Path: "hosts[0]", Old: host("5432"), New: host("6432")},
+		{Path: "password", Old: "***", New: "***"},
+		{Path: "port", Old: "5432", New: "6432"},
+	}, changes)
+
+	list := []interface{}{}
+	for i := 0; i < 12; i++ {
+		list = append(list, "static")
+	}
+	list[2], list[11] = "${DIFF_PORT}", "${DIFF_PORT}"
+	changes, err = Diff(list, lookupMap(oldValues), lookupMap(newValues))
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Path: "[2]", Old: "5432", New: "6432"},
+		{Path: "[11]", Old: "5432", New: "6432"},
+	}, changes)
+}
@@ -0,0 +1,118 @@
+package expandenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LookupDotenv resolves variables from a .env file. Values may reference
+// variables defined earlier in the same file, e.g. BIN=${BASE}/bin. Values
+// in single quotes are taken literally.
+func LookupDotenv(path string) (VariableLookup, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values, err := parseDotenv(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return lookupMap(values), nil
+}
+
+// LookupDotenvFS works like LookupDotenv but reads the file from fsys.
+func LookupDotenvFS(fsys fs.FS, path string) (VariableLookup, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values, err := parseDotenv(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return lookupMap(values), nil
+}
+
+// ExpandWithEnvReader expands with values read from r in the format of a
+// .env file, e.g. piped to stdin.
+func ExpandWithEnvReader(input interface{}, r io.Reader) (interface{}, error) {
+	values, err := parseDotenv(r)
+	if err != nil {
+		return input, err
+	}
+	return Expand(input, lookupMap(values))
+}
+
+func parseDotenv(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if end := closingQuote(value); end > 0 {
+			rest := strings.TrimSpace(value[end+1:])
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, fmt.Errorf("line %d: %s is not properly quoted", lineNumber, value)
+			}
+			value = value[:end+1]
+		}
+
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			values[key] = value[1 : len(value)-1]
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s is not properly quoted", lineNumber, value)
+			}
+			value = unquoted
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		expanded, err := expandString(value, lookupMap(values))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		values[key] = expanded
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// closingQuote returns the index of the quote closing a value starting with
+// ' or ", or -1. Within double quotes, quotes can be escaped with \.
+func closingQuote(value string) int {
+	if value == "" || (value[0] != '\'' && value[0] != '"') {
+		return -1
+	}
+	for i := 1; i < len(value); i++ {
+		if value[0] == '"' && value[i] == '\\' {
+			i++
+			continue
+		}
+		if value[i] == value[0] {
+			return i
+		}
+	}
+	return -1
+}
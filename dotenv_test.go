@@ -0,0 +1,104 @@
+package expandenv
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupDotenv(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ".env"), []byte(`# comment
+BASE=/opt
+export BIN=${BASE}/bin # inline comment
+QUOTED="${BIN}/app\n"
+LITERAL='${BASE}'
+COMMENTED="bar" # comment
+SINGLE='a # b' # comment
+ESCAPED="say \"hi\"" # comment
+`), 0o644)
+	assert.NoError(t, err)
+
+	lookup, err := LookupDotenv(filepath.Join(dir, ".env"))
+	assert.NoError(t, err)
+	output, err := Expand(map[string]interface{}{
+		"base":      "${BASE}",
+		"bin":       "${BIN}",
+		"quoted":    "${QUOTED}",
+		"literal":   "${LITERAL}",
+		"commented": "${COMMENTED}",
+		"single":    "${SINGLE}",
+		"escaped":   "${ESCAPED}",
+	}, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"base":      "/opt",
+		"bin":       "/opt/bin",
+		"quoted":    "/opt/bin/app\n",
+		"literal":   "${BASE}",
+		"commented": "bar",
+		"single":    "a # b",
+		"escaped":   `say "hi"`,
+	}, output)
+
+	_, err = Expand("${UNKNOWN}", lookup)
+	assert.EqualError(t, err, "variable UNKNOWN is missing")
+
+	err = os.WriteFile(filepath.Join(dir, "trailing.env"), []byte(`KEY="bar" baz
+`), 0o644)
+	assert.NoError(t, err)
+	_, err = LookupDotenv(filepath.Join(dir, "trailing.env"))
+	assert.EqualError(t, err, filepath.Join(dir, "trailing.env")+`: line 1: "bar" baz is not properly quoted`)
+
+	err = os.WriteFile(filepath.Join(dir, "later.env"), []byte(`BIN=${BASE}/bin
+BASE=/opt
+`), 0o644)
+	assert.NoError(t, err)
+	_, err = LookupDotenv(filepath.Join(dir, "later.env"))
+	assert.EqualError(t, err, filepath.Join(dir, "later.env")+": line 1: variable BASE is missing")
+}
+
+func TestLookupDotenvFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config/.env":   {Data: []byte("BASE=/opt\nBIN=${BASE}/bin\n")},
+		"config/broken": {Data: []byte("BROKEN\n")},
+	}
+
+	lookup, err := LookupDotenvFS(fsys, "config/.env")
+	assert.NoError(t, err)
+	output, err := Expand("${BIN}", lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "/opt/bin", output)
+
+	_, err = LookupDotenvFS(fsys, "config/broken")
+	assert.EqualError(t, err, "config/broken: line 1: expected KEY=VALUE")
+
+	_, err = LookupDotenvFS(fsys, "config/unknown")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestExpandWithEnvReader(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# piped\nHOST=db.local\nexport PORT=5432\nDSN=\"postgres://${HOST}:${PORT}\"\nRAW='${HOST}'\n")
+
+	output, err := ExpandWithEnvReader(map[string]interface{}{
+		"dsn":  "${DSN}",
+		"port": "${PORT:number}",
+		"raw":  "${RAW}",
+	}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"dsn":  "postgres://db.local:5432",
+		"port": 5432,
+		"raw":  "${HOST}",
+	}, output)
+
+	output, err = ExpandWithEnvReader("${HOST}", bytes.NewBufferString("HOST\n"))
+	assert.EqualError(t, err, "line 1: expected KEY=VALUE")
+	assert.Equal(t, "${HOST}", output)
+}
@@ -0,0 +1,32 @@
+package expandenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExpandEnviron2 expands the values of KEY=VALUE entries, as used by
+// exec.Cmd.Env. Keys are kept literally and only the first = separates the
+// key from the value, so values may contain = themselves.
+func ExpandEnviron2(template []string, values VariableLookup) ([]string, error) {
+	result := make([]string, len(template))
+	errs := []error{}
+	for i, entry := range template {
+		result[i] = entry
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			errs = append(errs, atPath(fmt.Sprintf("[%d]", i), fmt.Errorf("entry %s is not of the form KEY=VALUE", entry)))
+			continue
+		}
+		expanded, err := expandString(value, values)
+		if err != nil {
+			errs = append(errs, atPath(fmt.Sprintf("[%d]", i), err))
+			continue
+		}
+		result[i] = key + "=" + expanded
+	}
+	if len(errs) > 0 {
+		return result, joinErrors(errs)
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnviron2(t *testing.T) {
+	values := map[string]string{
+		"ENVIRON_HOST": "db.local",
+		"ENVIRON_OPTS": "a=1&b=2",
+	}
+
+	testCases := []struct {
+		input  []string
+		output []string
+		error  error
+	}{
+		{
+			input:  []string{"HOST=${ENVIRON_HOST}", "PLAIN=value"},
+			output: []string{"HOST=db.local", "PLAIN=value"},
+		},
+		{
+			input:  []string{"DSN=postgres://${ENVIRON_HOST}/app?sslmode=disable", "OPTS=${ENVIRON_OPTS}"},
+			output: []string{"DSN=postgres://db.local/app?sslmode=disable", "OPTS=a=1&b=2"},
+		},
+		{
+			input:  []string{"${ENVIRON_HOST}=${ENVIRON_HOST}", "EMPTY="},
+			output: []string{"${ENVIRON_HOST}=db.local", "EMPTY="},
+		},
+		{
+			input:  []string{"HOST=${ENVIRON_UNKNOWN}", "INVALID", "PORT=${ENVIRON_UNKNOWN:-80}"},
+			output: []string{"HOST=${ENVIRON_UNKNOWN}", "INVALID", "PORT=80"},
+			error:  fmt.Errorf("at [0]: variable ENVIRON_UNKNOWN is missing, at [1]: entry INVALID is not of the form KEY=VALUE"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := ExpandEnviron2(testCase.input, lookupMap(values))
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+}
@@ -0,0 +1,131 @@
+package expandenv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExpandError holds all errors of an expansion, ordered by their path.
+type ExpandError struct {
+	Errors []error
+
+	summarize int
+}
+
+func (e *ExpandError) Error() string {
+	errMsgs := []string{}
+	missing := e.Missing()
+	if e.summarize > 0 && len(missing) > e.summarize {
+		errMsgs = append(errMsgs, fmt.Sprintf("%d variables missing: %s, ... (+%d more)", len(missing), strings.Join(missing[:e.summarize], ", "), len(missing)-e.summarize))
+		for _, err := range e.Errors {
+			var missingErr *MissingError
+			if !errors.As(err, &missingErr) {
+				errMsgs = append(errMsgs, err.Error())
+			}
+		}
+		return strings.Join(errMsgs, ", ")
+	}
+	for _, err := range e.Errors {
+		errMsgs = append(errMsgs, err.Error())
+	}
+	return strings.Join(errMsgs, ", ")
+}
+
+func (e *ExpandError) Unwrap() []error {
+	return e.Errors
+}
+
+// Missing returns the names of all missing variables in the order they
+// were first encountered.
+func (e *ExpandError) Missing() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, err := range e.Errors {
+		var missingErr *MissingError
+		if errors.As(err, &missingErr) && !seen[missingErr.Name] {
+			seen[missingErr.Name] = true
+			names = append(names, missingErr.Name)
+		}
+	}
+	return names
+}
+
+// MissingError is returned for a variable the lookup could not resolve and
+// that has no fallback.
+type MissingError struct {
+	Name string
+	Err  error
+}
+
+func (e *MissingError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MissingError) Unwrap() error {
+	return e.Err
+}
+
+// formatError replaces the message of a failed format with a template from
+// Options.FormatErrors or with a redacted one. The original error is still
+// available via Unwrap.
+type formatError struct {
+	message string
+	err     error
+}
+
+func (e *formatError) Error() string {
+	return e.message
+}
+
+func (e *formatError) Unwrap() error {
+	return e.err
+}
+
+// customFormatError applies the template of Options.FormatErrors for format
+// to err, if there is one.
+func customFormatError(err error, name string, format string, value interface{}, options Options) error {
+	template, ok := options.FormatErrors[format]
+	if !ok {
+		return err
+	}
+	display := stringify(value)
+	if options.Redact || options.sensitive {
+		display = "***"
+	}
+	message := strings.NewReplacer("{name}", name, "{value}", display).Replace(template)
+	return &formatError{message: message, err: err}
+}
+
+// keyCollisionError reports two key templates expanding to the same key.
+func keyCollisionError(first string, second string, key string) error {
+	return fmt.Errorf("keys %s and %s both expand to '%s'", first, second, key)
+}
+
+// errorsAtPath adds path to err, or to each of the errors of an
+// *ExpandError, so they are reported like those of ExpandWithOptions.
+func errorsAtPath(path string, err error) []error {
+	var expandErr *ExpandError
+	if !errors.As(err, &expandErr) {
+		return []error{atPath(path, err)}
+	}
+	errs := make([]error, len(expandErr.Errors))
+	for i, err := range expandErr.Errors {
+		errs[i] = atPath(path, err)
+	}
+	return errs
+}
+
+// joinErrors combines errs into a single error. Errors are ordered by their
+// path, so the result does not depend on the iteration order of maps.
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	errs = append([]error{}, errs...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return pathLess(errorPath(errs[i]), errorPath(errs[j]))
+	})
+	return &ExpandError{Errors: errs}
+}
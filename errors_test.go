@@ -0,0 +1,59 @@
+package expandenv
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandSummarizeMissing(t *testing.T) {
+	values := lookupMap(map[string]string{"SUMMARY_PORT": "http"})
+	input := []interface{}{}
+	names := []string{}
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("SUMMARY_%c", 'A'+i)
+		names = append(names, name)
+		input = append(input, "${"+name+"}")
+	}
+	input = append(input, "${SUMMARY_A}", "${SUMMARY_PORT:number}")
+
+	_, err := ExpandWithOptions(input, values, Options{SummarizeMissing: 3})
+	assert.EqualError(t, err, "12 variables missing: SUMMARY_A, SUMMARY_B, SUMMARY_C, ... (+9 more), at [13]: http is not a valid number")
+	var expandErr *ExpandError
+	assert.True(t, errors.As(err, &expandErr))
+	assert.Equal(t, names, expandErr.Missing())
+	assert.Len(t, expandErr.Errors, 14)
+	var missingErr *MissingError
+	assert.True(t, errors.As(err, &missingErr))
+	assert.Equal(t, "SUMMARY_A", missingErr.Name)
+
+	_, err = ExpandWithOptions(input[:3], values, Options{SummarizeMissing: 3})
+	assert.EqualError(t, err, "at [0]: variable SUMMARY_A is missing, at [1]: variable SUMMARY_B is missing, at [2]: variable SUMMARY_C is missing")
+
+	_, err = ExpandWithOptions(input[:4], values, Options{})
+	assert.EqualError(t, err, "at [0]: variable SUMMARY_A is missing, at [1]: variable SUMMARY_B is missing, at [2]: variable SUMMARY_C is missing, at [3]: variable SUMMARY_D is missing")
+
+	failing := func(key string) (*string, error) {
+		if key == "SUMMARY_NET" {
+			return nil, errors.New("connection refused")
+		}
+		return values(key)
+	}
+	_, err = ExpandWithOptions([]interface{}{"${SUMMARY_NET}", "${SUMMARY_X}", "${SUMMARY_NET:-!err}"}, failing, Options{SummarizeMissing: 1})
+	assert.EqualError(t, err, "at [0]: connection refused, at [1]: variable SUMMARY_X is missing, at [2]: connection refused")
+	assert.True(t, errors.As(err, &expandErr))
+	assert.Equal(t, []string{"SUMMARY_X"}, expandErr.Missing())
+
+	_, err = ExpandWithOptions("${SUMMARY_X} ${SUMMARY_CYCLE}", func(key string) (*string, error) {
+		value := "${" + key + "}"
+		if key == "SUMMARY_X" {
+			return values(key)
+		}
+		return &value, nil
+	}, Options{Transitive: true})
+	assert.EqualError(t, err, "variable SUMMARY_X is missing, variable cycle detected: SUMMARY_CYCLE -> SUMMARY_CYCLE")
+	assert.True(t, errors.As(err, &expandErr))
+	assert.Equal(t, []string{"SUMMARY_X"}, expandErr.Missing())
+}
@@ -0,0 +1,42 @@
+// Package etcdlookup resolves variables from keys stored in etcd. It does not
+// depend on the etcd client itself, callers pass a small adapter instead:
+//
+//	type adapter struct{ kv clientv3.KV }
+//
+//	func (a adapter) Get(ctx context.Context, key string) ([]byte, bool, error) {
+//		resp, err := a.kv.Get(ctx, key)
+//		if err != nil {
+//			return nil, false, err
+//		}
+//		if len(resp.Kvs) == 0 {
+//			return nil, false, nil
+//		}
+//		return resp.Kvs[0].Value, true, nil
+//	}
+package etcdlookup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/airfocusio/go-expandenv"
+)
+
+type Client interface {
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+}
+
+// NewLookup resolves a variable NAME from the etcd key prefix + NAME.
+func NewLookup(client Client, prefix string) expandenv.VariableLookup {
+	return func(key string) (*string, error) {
+		bytes, found, err := client.Get(context.Background(), prefix+key)
+		if err != nil {
+			return nil, fmt.Errorf("etcd lookup of %s failed: %w", prefix+key, err)
+		}
+		if !found {
+			return nil, &expandenv.MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		value := string(bytes)
+		return &value, nil
+	}
+}
@@ -0,0 +1,70 @@
+package etcdlookup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/airfocusio/go-expandenv"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockClient map[string]string
+
+func (c mockClient) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if key == "/config/BROKEN" {
+		return nil, false, fmt.Errorf("connection refused")
+	}
+	value, ok := c[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return []byte(value), true, nil
+}
+
+func TestNewLookup(t *testing.T) {
+	lookup := NewLookup(mockClient{
+		"/config/ETCD_A": "a",
+		"ETCD_A":         "unprefixed",
+	}, "/config/")
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  "${ETCD_A}",
+			output: "a",
+			label:  "hit",
+		},
+		{
+			input:  "${ETCD_UNKNOWN}",
+			output: "${ETCD_UNKNOWN}",
+			label:  "miss",
+			error:  fmt.Errorf("variable ETCD_UNKNOWN is missing"),
+		},
+		{
+			input:  "${ETCD_UNKNOWN:-fallback}",
+			output: "fallback",
+			label:  "miss-fallback",
+		},
+		{
+			input:  "${BROKEN}",
+			output: "${BROKEN}",
+			label:  "transport-error",
+			error:  fmt.Errorf("etcd lookup of /config/BROKEN failed: connection refused"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := expandenv.Expand(testCase.input, lookup)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+}
@@ -1,118 +1,998 @@
 package expandenv
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
 )
 
+// VariableLookup resolves the value of a variable. Unknown variables should
+// be reported with a *MissingError, so that other errors can be told apart,
+// e.g. by SourceSet.
 type VariableLookup = func(key string) (*string, error)
 
+type Options struct {
+	// NameMapper rewrites each placeholder name before it is looked up,
+	// e.g. to map ${db-host} to DB_HOST.
+	NameMapper func(name string) string
+	// FailFast stops the expansion at the first error instead of collecting
+	// all of them. Everything not yet visited is returned unchanged.
+	FailFast bool
+	// ExpandFallbackEnv expands shell style $NAME references in fallback
+	// values from the process environment, as in ${CONFIG:-$HOME/config}.
+	// Braced references are left alone.
+	ExpandFallbackEnv bool
+	// Observer is notified about resolved variables, used fallbacks, applied
+	// formats and encountered errors.
+	Observer Observer
+	// FallbackFiles loads fallbacks starting with @ from the file they name,
+	// e.g. ${CONFIG:-@default.yaml}. A leading @@ produces a literal @.
+	FallbackFiles bool
+	// FallbackFileDir is the directory relative fallback files are resolved
+	// against. Defaults to the working directory.
+	FallbackFileDir string
+	// FallbackFS is the file system fallback files are read from instead of
+	// the real one, e.g. an embed.FS. FallbackFileDir is resolved within it.
+	FallbackFS fs.FS
+	// DynamicFallbacks enables the special fallbacks @now (the current time
+	// in RFC 3339 format) and @uuid (a random UUID). A leading @@ produces a
+	// literal @.
+	DynamicFallbacks bool
+	// Idempotent returns expanded strings that would be changed by another
+	// expansion pass, e.g. because a value contained ${...}, as Literal. This
+	// guarantees that expanding the output again with the same values is a
+	// no-op.
+	Idempotent bool
+	// ExpandStringers expands the String() representation of values
+	// implementing fmt.Stringer. If anything was expanded, the result is
+	// stored back as string, otherwise the original value is kept.
+	ExpandStringers bool
+	// PreserveTypes keeps explicitly tagged YAML scalars like !!int ${PORT}
+	// at their original type when expanding nodes with ExpandNode. Results
+	// that cannot be represented as that type are rejected.
+	PreserveTypes bool
+	// AllowNames restricts the variables a template may reference to those
+	// matching at least one of the given glob patterns.
+	AllowNames []string
+	// DenyNames rejects variables matching any of the given glob patterns.
+	// It takes precedence over AllowNames.
+	DenyNames []string
+	// FloatFormat controls how floats produced by the number format are
+	// rendered. By default plain float64 values are returned.
+	FloatFormat FloatFormat
+	// Redact replaces values with *** in error messages, so secrets do not
+	// leak into logs. The variable name is added instead.
+	Redact bool
+	// Formats holds custom formats by name. They take precedence over the
+	// built-in ones. With Redact, every occurrence of the value in their
+	// errors is replaced with ***.
+	Formats map[string]FormatFunc
+	// ExpandYAMLMarshalers expands values implementing yaml.Marshaler by
+	// marshalling them to YAML, expanding the result and unmarshalling it
+	// back into the original type.
+	ExpandYAMLMarshalers bool
+	// PipeSyntax additionally accepts placeholders like
+	// ${VAR | trim | lower | default:x}. Filters map to the formats and
+	// markers of the colon syntax, arguments are given after a colon instead
+	// of =. The default works like a fallback, but filters before it are not
+	// applied to it.
+	PipeSyntax bool
+	// ExpandKeys also expands the keys of maps. Keys must expand to strings
+	// and no two keys of the same map may expand to the same result.
+	ExpandKeys bool
+	// OutputEscaping escapes every resolved string for the given target.
+	OutputEscaping OutputEscaping
+	// AllOrNothing returns nil instead of the partially expanded output if
+	// any error occurred.
+	AllOrNothing bool
+	// ExpandRawJSON descends into json.RawMessage values, including those
+	// of a map[string]json.RawMessage, by decoding them, expanding the
+	// result and encoding it again.
+	ExpandRawJSON bool
+	// ExpandEmbeddedJSON treats strings holding a JSON object or array as
+	// nested documents. Placeholders inside are expanded and the result is
+	// encoded back into a string.
+	ExpandEmbeddedJSON bool
+	// AllowedCommands enables fallbacks like ${REV:-$(git rev-parse HEAD)}
+	// for the listed command lines, e.g. "git rev-parse HEAD". Only the
+	// literal fallback text is considered, neither nested placeholders nor
+	// environment variables make up a command. Any other command is
+	// rejected.
+	AllowedCommands []string
+	// CommandRunner runs the commands of command fallbacks and returns their
+	// output. Trailing newlines are removed. Defaults to os/exec.
+	CommandRunner func(name string, args ...string) (string, error)
+	// JSONSafe converts integers produced by formats to json.Number and
+	// rejects format results that cannot be represented in JSON, like NaN
+	// or a time.Duration.
+	JSONSafe bool
+	// ExpandBinaryText expands []byte values if they hold valid UTF-8 text.
+	// The result is converted back to []byte.
+	ExpandBinaryText bool
+	// Node controls how ExpandNode walks a document.
+	Node NodeOptions
+	// SummarizeMissing shortens the error message if more than the given
+	// number of variables are missing, e.g. to "12 variables missing: A, B,
+	// C, ... (+9 more)". The full list is still available from ExpandError.
+	SummarizeMissing int
+	// Transitive expands placeholders in resolved values as well, e.g. with
+	// A=${B} and B=c, ${A} becomes c. Cycles are reported as error.
+	Transitive bool
+	// MaxDepth is the maximum nesting depth of the input. Deeper documents
+	// are rejected instead of risking a stack overflow. Defaults to 1000.
+	MaxDepth int
+	// EmptyEnv controls how environment variables that are set to an empty
+	// string are treated by ExpandEnvWithOptions and the env marker.
+	EmptyEnv EmptyEnv
+	// KeepEmptyNames leaves placeholders without a variable name, like ${}
+	// or ${:-x}, as they are instead of reporting "empty variable name".
+	KeepEmptyNames bool
+	// OnlyFormatted only expands placeholders with a format, like
+	// ${PORT:number}. Others, like ${HOST} or ${HOST:-localhost}, are left
+	// as they are.
+	OnlyFormatted bool
+	// FormatErrors replaces the error message of a failing format, keyed by
+	// the format name, e.g. {"number": "{name} must be a port, got {value}"}.
+	// {name} and {value} are replaced by the variable name and its value.
+	FormatErrors map[string]string
+
+	rewriteUnresolved func(name string) string
+	keyLookup         VariableLookup
+	seed              *string
+	typedValues       map[string]interface{}
+	sensitive         bool
+	placeholders      map[string]string
+	leaves            map[string]interface{}
+}
+
+// FormatFunc converts a resolved value for a custom format like
+// ${VERSION:semver}.
+type FormatFunc = func(value string) (interface{}, error)
+
+// OutputEscaping selects how resolved strings are escaped before they are
+// inserted, see Options.OutputEscaping.
+type OutputEscaping string
+
+const (
+	OutputEscapingNone OutputEscaping = ""
+	// OutputEscapingShell single quotes values unless they only consist of
+	// characters that are safe in a shell.
+	OutputEscapingShell OutputEscaping = "shell"
+	// OutputEscapingJSON escapes values for use inside a JSON string.
+	OutputEscapingJSON OutputEscaping = "json"
+)
+
+// EmptyEnv selects how environment variables that are set but empty are
+// treated, see Options.EmptyEnv.
+type EmptyEnv string
+
+const (
+	// EmptyEnvPresent uses empty environment variables as they are.
+	EmptyEnvPresent EmptyEnv = ""
+	// EmptyEnvFallback treats empty environment variables like unset ones,
+	// so the fallback is used if there is one.
+	EmptyEnvFallback EmptyEnv = "fallback"
+	// EmptyEnvError rejects empty environment variables, even if there is a
+	// fallback.
+	EmptyEnvError EmptyEnv = "error"
+)
+
+// FloatFormat holds the arguments passed to strconv.FormatFloat.
+type FloatFormat struct {
+	Format    byte
+	Precision int
+}
+
+// Literal is a string that has already been expanded. It is never touched by
+// Expand.
+type Literal string
+
 func ExpandEnv(input interface{}) (interface{}, error) {
-	return Expand(input, func(key string) (*string, error) {
-		value, ok := os.LookupEnv(key)
-		if !ok {
-			return nil, fmt.Errorf("environment variable %s is missing", key)
-		}
-		return &value, nil
+	return Expand(input, lookupEnv)
+}
+
+// ExpandEnvWithOptions works like ExpandEnv but takes options, e.g. to
+// resolve environment variables referencing each other with Transitive.
+func ExpandEnvWithOptions(input interface{}, options Options) (interface{}, error) {
+	return ExpandWithOptions(input, envLookup(options.EmptyEnv), options)
+}
+
+// ExpandEnvAssign works like ExpandEnv, but mirrors the shell's ${VAR:=...}
+// by writing every used fallback back to the process environment. Later
+// references and child processes see the assigned value. Only string
+// fallbacks are written, so ${VAR:-[]} or a nested ${VAR:-${PORT:number}}
+// leave VAR unset, as do fallbacks of placeholders marked as secret.
+func ExpandEnvAssign(input interface{}) (interface{}, error) {
+	errs := []error{}
+	output, err := ExpandWithOptions(input, lookupEnv, Options{
+		Observer: func(event Event) {
+			value, ok := event.Value.(string)
+			if event.Kind != EventFallback || !ok || event.Sensitive {
+				return
+			}
+			if err := os.Setenv(event.Name, value); err != nil {
+				errs = append(errs, err)
+			}
+		},
 	})
+	if err != nil {
+		errs = append([]error{err}, errs...)
+	}
+	return output, joinErrors(errs)
+}
+
+func lookupEnv(key string) (*string, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil, &MissingError{Name: key, Err: fmt.Errorf("environment variable %s is missing", key)}
+	}
+	return &value, nil
+}
+
+// emptyEnvError is returned for environment variables that are set to an
+// empty string if options.EmptyEnv is not EmptyEnvPresent. With
+// EmptyEnvFallback it is wrapped into a *MissingError.
+type emptyEnvError struct {
+	name string
+}
+
+func (e *emptyEnvError) Error() string {
+	return fmt.Sprintf("environment variable %s is empty", e.name)
+}
+
+func envLookup(mode EmptyEnv) VariableLookup {
+	if mode == EmptyEnvPresent {
+		return lookupEnv
+	}
+	return func(key string) (*string, error) {
+		value, err := lookupEnv(key)
+		if err == nil && *value == "" && mode == EmptyEnvFallback {
+			return nil, &MissingError{Name: key, Err: &emptyEnvError{name: key}}
+		}
+		if err == nil && *value == "" {
+			return nil, &emptyEnvError{name: key}
+		}
+		return value, err
+	}
 }
 
 func ExpandMap(input interface{}, values map[string]string) (interface{}, error) {
+	return Expand(input, lookupMap(values))
+}
+
+// ExpandMapMerge expands with the values of all maps merged. If a name is
+// present in several maps, the value of the last one wins.
+func ExpandMapMerge(input interface{}, maps ...map[string]string) (interface{}, error) {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return ExpandMap(input, merged)
+}
+
+// ExpandKeysValues expands map keys with keyLookup and everything else with
+// valueLookup, e.g. to take the structure from one source and secrets from
+// another.
+func ExpandKeysValues(input interface{}, keyLookup VariableLookup, valueLookup VariableLookup) (interface{}, error) {
+	return ExpandWithOptions(input, valueLookup, Options{ExpandKeys: true, keyLookup: keyLookup})
+}
+
+// ExpandWithOverrides expands with the values of base, except for those
+// names present in overrides.
+func ExpandWithOverrides(input interface{}, base VariableLookup, overrides map[string]string) (interface{}, error) {
 	return Expand(input, func(key string) (*string, error) {
+		if value, ok := overrides[key]; ok {
+			return &value, nil
+		}
+		return base(key)
+	})
+}
+
+// ExpandMapKeepMissing works like ExpandMap, but never fails. Instead it
+// returns the sorted names of all missing variables, which are left verbatim
+// in the output.
+func ExpandMapKeepMissing(input interface{}, values map[string]string) (interface{}, []string) {
+	output, err := ExpandWithOptions(input, lookupMap(values), Options{})
+	names := []string{}
+	var expandErr *ExpandError
+	if errors.As(err, &expandErr) {
+		names = expandErr.Missing()
+	}
+	sort.Strings(names)
+	return output, names
+}
+
+func lookupMap(values map[string]string) VariableLookup {
+	return func(key string) (*string, error) {
 		value, ok := values[key]
 		if !ok {
-			return nil, fmt.Errorf("variable %s is missing", key)
+			return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
 		}
 		return &value, nil
-	})
+	}
+}
+
+func MustExpandEnv(input interface{}) interface{} {
+	return must(ExpandEnv(input))
+}
+
+func MustExpandMap(input interface{}, values map[string]string) interface{} {
+	return must(ExpandMap(input, values))
+}
+
+func MustExpand(input interface{}, values VariableLookup) interface{} {
+	return must(Expand(input, values))
+}
+
+func must(output interface{}, err error) interface{} {
+	if err != nil {
+		panic(err)
+	}
+	return output
 }
 
 func Expand(input interface{}, values VariableLookup) (interface{}, error) {
-	singleRegex := regexp.MustCompile(`^\$\{[^\}]+\}$`)
-	detectRegex := regexp.MustCompile(`\\?\$\{[^\}]+\}`)
-	var recursion func(current interface{}) (interface{}, []error)
-	recursion = func(current interface{}) (interface{}, []error) {
-		if current, ok := current.(string); ok {
-			p := singleRegex.FindStringSubmatch(current)
-			if p != nil {
-				expanded, err := expandValue(current, values)
+	return ExpandWithOptions(input, values, Options{})
+}
+
+// ExpandWithOptions expands all placeholders in input. On error the output
+// is still returned, expanded as far as possible: every placeholder that
+// failed is left verbatim, no matter whether it makes up a whole value or is
+// embedded into a longer string.
+func ExpandWithOptions(input interface{}, values VariableLookup, options Options) (interface{}, error) {
+	output, _, err := expand(input, values, options)
+	return output, err
+}
+
+// expand is ExpandWithOptions additionally reporting whether anything was
+// substituted, as tracked by the recursion.
+func expand(input interface{}, values VariableLookup, options Options) (interface{}, bool, error) {
+	keyValues := options.keyLookup
+	if options.Transitive {
+		values = transitiveLookup(values, options, nil)
+		if keyValues != nil {
+			keyValues = transitiveLookup(keyValues, options, nil)
+		}
+	}
+	failed := false
+	literal := func(value interface{}) interface{} {
+		if str, ok := value.(string); ok && options.Idempotent && hasPlaceholders(str) {
+			return Literal(str)
+		}
+		return value
+	}
+	depth := 0
+	maxDepth := options.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+	// retypeLeaf replaces the leaf recorded for path by the string branch
+	// with its converted value, e.g. a []byte, keeping it redacted.
+	retypeLeaf := func(path string, value interface{}) {
+		if leaf, ok := options.leaves[path]; ok {
+			if _, sensitive := leaf.(sensitiveLeaf); sensitive {
+				value = sensitiveLeaf{value: value}
+			}
+			options.leaves[path] = value
+		}
+	}
+	var recursion func(current interface{}, path string) (interface{}, bool, []error)
+	expandKey := func(key string, path string) (string, []error) {
+		if keyValues != nil {
+			valueLookup := values
+			values = keyValues
+			defer func() { values = valueLookup }()
+		}
+		expanded, _, errs := recursion(key, joinPath(path, key))
+		if len(errs) > 0 {
+			return key, errs
+		}
+		if rv := reflect.ValueOf(expanded); rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+		return key, []error{atPath(path, fmt.Errorf("key %s does not expand to a string", key))}
+	}
+	recursion = func(current interface{}, path string) (interface{}, bool, []error) {
+		if failed {
+			return current, false, []error{}
+		}
+		if isStatic(current) {
+			return current, false, nil
+		}
+		if depth >= maxDepth {
+			failed = true
+			return current, false, []error{atPath(path, fmt.Errorf("document nesting too deep"))}
+		}
+		depth++
+		defer func() { depth-- }()
+		if current, ok := current.(string); ok && options.ExpandEmbeddedJSON {
+			if decoded, ok := embeddedJSON(current); ok {
+				expanded, changed, errs := recursion(decoded, path)
+				if !changed {
+					return current, false, errs
+				}
+				encoded, err := encodeJSON(expanded)
 				if err != nil {
-					return current, []error{err}
+					return current, false, append(errs, atPath(path, err))
 				}
-				return expanded, nil
+				return string(encoded), true, errs
 			}
+		}
+		if current, ok := current.(string); ok {
+			single := isSinglePlaceholder(current)
+			var typed interface{} = current
+			changed := false
+			sensitive := options.sensitive
 			errs := []error{}
-			expanded := detectRegex.ReplaceAllStringFunc(current, func(str string) string {
+			expanded := replacePlaceholders(current, func(str string) string {
 				if strings.HasPrefix(str, "\\") {
+					changed = true
 					return str[1:]
 				}
+				if failed {
+					return str
+				}
 
-				expanded, err := expandValue(str, values)
+				expanded, err := expandValue(str, values, options)
 				if err != nil {
+					err = atPath(path, err)
+					failed = options.FailFast
+					options.notify(Event{Kind: EventError, Err: err})
 					errs = append(errs, err)
 					return str
 				}
 
-				return fmt.Sprintf("%v", expanded)
+				expanded, err = escapeOutput(expanded, options)
+				if err != nil {
+					errs = append(errs, atPath(path, err))
+					return str
+				}
+				typed = expanded
+				changed = true
+				sensitive = sensitive || isSecretPlaceholder(str, options)
+				return stringify(expanded)
 			})
-			return expanded, errs
+			if len(errs) > 0 {
+				return expanded, changed, errs
+			}
+			if options.placeholders != nil && expanded != current {
+				options.placeholders[path] = current
+			}
+			if options.leaves != nil {
+				var leaf interface{} = expanded
+				if single {
+					leaf = typed
+				}
+				if sensitive {
+					leaf = sensitiveLeaf{value: leaf}
+				}
+				options.leaves[path] = leaf
+			}
+			if single {
+				return literal(typed), changed, errs
+			}
+			return literal(expanded), changed, errs
+		}
+		if marshaler, ok := current.(yaml.Marshaler); ok && options.ExpandYAMLMarshalers {
+			raw, err := roundTripYAML(marshaler, nil)
+			if err != nil {
+				return current, false, []error{atPath(path, err)}
+			}
+			expanded, changed, errs := recursion(raw, path)
+			if !changed {
+				return current, false, errs
+			}
+			result, err := roundTripYAML(expanded, reflect.TypeOf(current))
+			if err != nil {
+				return current, false, append(errs, atPath(path, err))
+			}
+			return result, true, errs
+		}
+		if stringer, ok := current.(fmt.Stringer); ok && options.ExpandStringers {
+			str := stringer.String()
+			expanded, changed, errs := recursion(str, path)
+			if !changed {
+				return current, false, errs
+			}
+			return fmt.Sprintf("%v", expanded), true, errs
+		}
+		if data, ok := current.([]byte); ok && options.ExpandBinaryText && utf8.Valid(data) {
+			expanded, changed, errs := recursion(string(data), path)
+			str, ok := expanded.(string)
+			if !ok {
+				return current, false, append(errs, atPath(path, fmt.Errorf("%s does not expand to a string", data)))
+			}
+			if !changed {
+				return current, false, errs
+			}
+			retypeLeaf(path, []byte(str))
+			return []byte(str), true, errs
+		}
+		if raw, ok := current.(json.RawMessage); ok && options.ExpandRawJSON {
+			decoded, err := decodeJSON(raw)
+			if err != nil {
+				return current, false, []error{atPath(path, err)}
+			}
+			expanded, changed, errs := recursion(decoded, path)
+			if !changed {
+				return current, false, errs
+			}
+			encoded, err := encodeJSON(expanded)
+			if err != nil {
+				return current, false, append(errs, atPath(path, err))
+			}
+			return json.RawMessage(encoded), true, errs
+		}
+		if current, ok := current.(map[string]json.RawMessage); ok && options.ExpandRawJSON {
+			errs := []error{}
+			current2 := map[string]json.RawMessage{}
+			changed := false
+			for k, v := range current {
+				v, vChanged, err := recursion(v, joinPath(path, k))
+				if err != nil {
+					errs = append(errs, err...)
+				}
+				changed = changed || vChanged
+				current2[k] = v.(json.RawMessage)
+			}
+			if !changed {
+				return current, false, errs
+			}
+			return current2, true, errs
 		}
 		if current, ok := current.([]interface{}); ok {
-			current2 := make([]interface{}, len(current))
+			var current2 []interface{}
 			errs := []error{}
 			for i := range current {
-				v, err := recursion(current[i])
+				if isStatic(current[i]) {
+					continue
+				}
+				v, changed, err := recursion(current[i], fmt.Sprintf("%s[%d]", path, i))
 				if err != nil {
 					errs = append(errs, err...)
 				}
-				current2[i] = v
+				if current2 == nil && changed {
+					current2 = append([]interface{}{}, current...)
+				}
+				if current2 != nil {
+					current2[i] = v
+				}
 			}
-			return current2, errs
+			if current2 == nil {
+				return current, false, errs
+			}
+			return current2, true, errs
 		}
 		if current, ok := current.(map[string]interface{}); ok {
 			errs := []error{}
-			current2 := map[string]interface{}{}
+			if options.ExpandKeys {
+				current2 := map[string]interface{}{}
+				changed := false
+				origins := map[string]string{}
+				for _, k := range sortedKeys(current) {
+					key, err := expandKey(k, path)
+					if err != nil {
+						errs = append(errs, err...)
+						current2[k] = current[k]
+						continue
+					}
+					if origin, ok := origins[key]; ok {
+						errs = append(errs, atPath(path, keyCollisionError(origin, k, key)))
+						changed = true
+						continue
+					}
+					origins[key] = k
+					v, vChanged, err := recursion(current[k], joinPath(path, key))
+					if err != nil {
+						errs = append(errs, err...)
+					}
+					changed = changed || key != k || vChanged
+					current2[key] = v
+				}
+				if !changed {
+					return current, false, errs
+				}
+				return current2, true, errs
+			}
+			var current2 map[string]interface{}
 			for k, v := range current {
-				v, err := recursion(v)
+				if isStatic(v) {
+					continue
+				}
+				v2, changed, err := recursion(v, joinPath(path, k))
 				if err != nil {
 					errs = append(errs, err...)
 				}
-				current2[k] = v
+				if current2 == nil && changed {
+					current2 = make(map[string]interface{}, len(current))
+					for k, v := range current {
+						current2[k] = v
+					}
+				}
+				if current2 != nil {
+					current2[k] = v2
+				}
 			}
-			return current2, errs
+			if current2 == nil {
+				return current, false, errs
+			}
+			return current2, true, errs
 		}
-		return current, []error{}
+		if rv := reflect.ValueOf(current); rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String && rv.Type().Elem().Kind() == reflect.Slice && rv.Type().Elem().Elem().Kind() == reflect.String {
+			if rv.IsNil() {
+				return current, false, []error{}
+			}
+			keys := make([]string, 0, rv.Len())
+			for _, k := range rv.MapKeys() {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			current2 := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+			changed := false
+			errs := []error{}
+			for _, k := range keys {
+				key := reflect.ValueOf(k).Convert(rv.Type().Key())
+				elem := rv.MapIndex(key)
+				v, vChanged, err := recursion(elem.Interface(), joinPath(path, k))
+				if err != nil {
+					errs = append(errs, err...)
+				}
+				changed = changed || vChanged
+				current2.SetMapIndex(key, reflect.ValueOf(v))
+			}
+			if !changed {
+				return current, false, errs
+			}
+			return current2.Interface(), true, errs
+		}
+		if rv := reflect.ValueOf(current); (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() == reflect.String {
+			if rv.Kind() == reflect.Slice && rv.IsNil() {
+				return current, false, []error{}
+			}
+			current2 := reflect.New(rv.Type()).Elem()
+			if rv.Kind() == reflect.Slice {
+				current2 = reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+			}
+			changed := false
+			errs := []error{}
+			for i := 0; i < rv.Len(); i++ {
+				str := rv.Index(i).String()
+				elemPath := fmt.Sprintf("%s[%d]", path, i)
+				v, vChanged, err := recursion(str, elemPath)
+				if err != nil {
+					errs = append(errs, err...)
+				}
+				if reflect.ValueOf(v).Kind() != reflect.String {
+					errs = append(errs, atPath(elemPath, fmt.Errorf("%s does not expand to a string", str)))
+					v, vChanged = str, false
+				}
+				changed = changed || vChanged
+				current2.Index(i).Set(reflect.ValueOf(v).Convert(rv.Type().Elem()))
+				retypeLeaf(elemPath, current2.Index(i).Interface())
+			}
+			return current2.Interface(), changed, errs
+		}
+		if rv := reflect.ValueOf(current); rv.Kind() == reflect.Pointer && rv.Type().Elem().Kind() == reflect.String {
+			if rv.IsNil() {
+				return current, false, []error{}
+			}
+			elem := rv.Elem().Interface()
+			expanded, changed, errs := recursion(elem, path)
+			if !changed {
+				return current, false, errs
+			}
+			if reflect.ValueOf(expanded).Kind() != reflect.String {
+				return current, false, append(errs, atPath(path, fmt.Errorf("%s does not expand to a string", rv.Elem().String())))
+			}
+			current2 := reflect.New(rv.Type().Elem())
+			current2.Elem().Set(reflect.ValueOf(expanded).Convert(rv.Type().Elem()))
+			retypeLeaf(path, current2.Elem().Interface())
+			return current2.Interface(), true, errs
+		}
+		if _, ok := current.(Literal); !ok {
+			if rv := reflect.ValueOf(current); rv.Kind() == reflect.String {
+				str := rv.String()
+				expanded, changed, errs := recursion(str, path)
+				if reflect.ValueOf(expanded).Kind() != reflect.String {
+					return current, false, append(errs, atPath(path, fmt.Errorf("%s does not expand to a string", str)))
+				}
+				if !changed {
+					return current, false, errs
+				}
+				converted := reflect.ValueOf(expanded).Convert(rv.Type()).Interface()
+				retypeLeaf(path, converted)
+				return converted, true, errs
+			}
+		}
+		return current, false, []error{}
+	}
+	output, changed, errs := recursion(input, "")
+	err := joinErrors(errs)
+	if err != nil && options.SummarizeMissing > 0 {
+		err.(*ExpandError).summarize = options.SummarizeMissing
 	}
-	output, errs := recursion(input)
-	if len(errs) > 0 {
-		errMsgs := []string{}
-		for _, err := range errs {
-			errMsgs = append(errMsgs, err.Error())
+	if err != nil && options.AllOrNothing {
+		return nil, false, err
+	}
+	return output, changed, err
+}
+
+// PathError is an error that occurred at a specific location of the
+// expanded document, e.g. spec.containers[0].image.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("at %s: %v", e.Path, e.Err)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+func atPath(path string, err error) error {
+	if path == "" {
+		return err
+	}
+	return &PathError{Path: path, Err: err}
+}
+
+func errorPath(err error) string {
+	if pathErr, ok := err.(*PathError); ok {
+		return pathErr.Path
+	}
+	return ""
+}
+
+// pathSegmentRegex matches a single key or array index of a path.
+var pathSegmentRegex = regexp.MustCompile(`\[(\d+)\]|[^.\[]+`)
+
+// pathLess orders paths segment by segment, comparing array indices
+// numerically, so that a[2] comes before a[10].
+func pathLess(a string, b string) bool {
+	as := pathSegmentRegex.FindAllStringSubmatch(a, -1)
+	bs := pathSegmentRegex.FindAllStringSubmatch(b, -1)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i][1] != "" && bs[i][1] != "" {
+			ai, _ := strconv.Atoi(as[i][1])
+			bi, _ := strconv.Atoi(bs[i][1])
+			if ai != bi {
+				return ai < bi
+			}
+			continue
+		}
+		if as[i][0] != bs[i][0] {
+			return as[i][0] < bs[i][0]
 		}
-		return output, fmt.Errorf(strings.Join(errMsgs, ", "))
 	}
-	return output, nil
+	return len(as) < len(bs)
 }
 
-func expandValue(str string, values VariableLookup) (interface{}, error) {
-	regex := regexp.MustCompile(`^\$\{(?P<name>[^:]+)(?P<hasFormat>:(?P<format>number|boolean|string))?(?P<hasFallback>:-(?P<fallback>.*))?\}$`)
-	p := regex.FindStringSubmatch(str)
-	if p == nil {
-		return nil, fmt.Errorf("could not parse %s", str)
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// RewriteUnresolved expands everything it can and replaces all placeholders
+// that could neither be resolved nor fall back to a default by the result of
+// rewrite. This allows handing them over to another tool in its own syntax,
+// e.g. ${X} to {{X}}. Only variables reported missing with a *MissingError
+// are rewritten, other lookup errors are returned as usual.
+func RewriteUnresolved(input interface{}, values VariableLookup, rewrite func(name string) string) (interface{}, error) {
+	return ExpandWithOptions(input, values, Options{rewriteUnresolved: rewrite})
+}
+
+// ExpandWithChanged works like ExpandWithOptions but additionally reports
+// whether anything was substituted, even if the result equals the input.
+// The recursion tracks this while expanding, so the output is not compared
+// with the input afterwards.
+func ExpandWithChanged(input interface{}, values VariableLookup, options Options) (interface{}, bool, error) {
+	return expand(input, values, options)
+}
+
+// roundTripYAML marshals value to YAML and unmarshals it into a new value of
+// the given type, or into interface{} if typ is nil.
+func roundTripYAML(value interface{}, typ reflect.Type) (interface{}, error) {
+	bytes, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if typ == nil {
+		var result interface{}
+		err := yaml.Unmarshal(bytes, &result)
+		return result, err
+	}
+	result := reflect.New(typ)
+	if err := yaml.Unmarshal(bytes, result.Interface()); err != nil {
+		return nil, err
+	}
+	return result.Elem().Interface(), nil
+}
+
+// shellSafeRegex matches strings that need no quoting in a shell.
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// escapeOutput escapes a resolved string for options.OutputEscaping. Other
+// values are returned as they are.
+func escapeOutput(value interface{}, options Options) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	switch options.OutputEscaping {
+	case OutputEscapingNone:
+		return value, nil
+	case OutputEscapingShell:
+		if shellSafeRegex.MatchString(str) {
+			return str, nil
+		}
+		return formatValue(str, "shellquote", "", options)
+	case OutputEscapingJSON:
+		return formatValue(str, "json-escape", "", options)
+	default:
+		return nil, fmt.Errorf("output escaping %s is not supported", options.OutputEscaping)
+	}
+}
+
+// isStatic tells whether a value can be skipped by the recursion because it
+// cannot contain any placeholder.
+func isStatic(value interface{}) bool {
+	switch value := value.(type) {
+	case nil, bool, int, int64, uint64, float64:
+		return true
+	case string:
+		return !strings.Contains(value, "${")
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func expandValue(str string, values VariableLookup, options Options) (interface{}, error) {
+	p, err := parseWithOptions(str, options)
+	if errors.Is(err, errEmptyName) && options.KeepEmptyNames {
+		return str, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := p.name
+	hasFallback := p.hasFallback
+	fallback := p.fallback
+	templateName := name
+	markers, modifiers, err := splitMarkers(p.modifiers)
+	if err != nil {
+		return nil, err
+	}
+	options.sensitive = options.sensitive || markers.secret
+	p.modifiers = modifiers
+	if options.OnlyFormatted && len(modifiers) == 0 {
+		return str, nil
+	}
+	lookup := values
+	if markers.env {
+		lookup = envLookup(options.EmptyEnv)
+	}
+	indirect := strings.HasPrefix(name, "!")
+	if indirect {
+		name = name[1:]
+		lookup = indirectLookup(lookup, options)
+	}
+	name, err = mapName(name, options)
+	if err != nil {
+		return nil, err
+	}
+	if markers.seed != "" {
+		seed, err := lookupSeed(markers.seed, values, options)
+		if err != nil {
+			return nil, err
+		}
+		// The flag name is part of the seed, so flags sharing a seed
+		// variable are rolled out to different subsets.
+		seed = name + ":" + seed
+		options.seed = &seed
+	}
+	if len(markers.alternatives) > 0 {
+		lookup, err = coalesceLookup(lookup, markers.alternatives, hasFallback, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	options.notify(Event{Kind: EventReference, Name: name})
+	value, err := lookup(name)
+	usedFallback := false
+	var emptyErr *emptyEnvError
+	if err != nil && options.EmptyEnv == EmptyEnvError && errors.As(err, &emptyErr) {
+		return nil, err
 	}
-	name := p[regex.SubexpIndex("name")]
-	format := p[regex.SubexpIndex("format")]
-	hasFallback := p[regex.SubexpIndex("hasFallback")] != ""
-	fallback := p[regex.SubexpIndex("fallback")]
-	value, err := values(name)
 	if err != nil {
 		if !hasFallback {
+			var missingErr *MissingError
+			if options.rewriteUnresolved != nil && errors.As(err, &missingErr) {
+				return options.rewriteUnresolved(templateName), nil
+			}
 			return nil, err
 		} else {
+			if message, ok := errorFallback(fallback); ok {
+				var missingErr *MissingError
+				if message == "" || !errors.As(err, &missingErr) {
+					return nil, err
+				}
+				return nil, &MissingError{Name: name, Err: errors.New(message)}
+			}
+			// !!err escapes an error fallback, other fallbacks are kept.
+			if _, ok := errorFallback(strings.TrimPrefix(fallback, "!")); ok && strings.HasPrefix(fallback, "!!") {
+				fallback = fallback[1:]
+			}
+			if !appliesToFallback(p.modifiers) {
+				if empty, ok := emptyFallbacks[fallback]; ok {
+					options.notify(Event{Kind: EventFallback, Name: name, Value: empty()})
+					return empty(), nil
+				}
+			}
+			if hasPlaceholders(fallback) {
+				nestedOptions := options
+				nestedOptions.OutputEscaping = OutputEscapingNone
+				nestedOptions.placeholders = nil
+				nestedOptions.leaves = nil
+				nested, err := ExpandWithOptions(fallback, values, nestedOptions)
+				if err != nil {
+					return nil, err
+				}
+				// Expanded values are used as they are. Only the fallback
+				// written in the template may name files or commands.
+				if str, ok := nested.(string); ok {
+					fallback = str
+				} else {
+					options.notify(Event{Kind: EventFallback, Name: name, Value: nested})
+					return nested, nil
+				}
+			} else {
+				fallback, err = resolveFallback(fallback, options)
+				if err != nil {
+					return nil, err
+				}
+			}
 			value = &fallback
+			usedFallback = true
+			options.notify(Event{Kind: EventFallback, Name: name, Value: fallback})
+		}
+	} else if value != nil {
+		options.notify(Event{Kind: EventResolved, Name: name, Value: *value})
+		if typed, ok := options.typedValues[name]; ok && len(p.modifiers) == 0 && len(markers.alternatives) == 0 && !indirect && !markers.env {
+			return typed, nil
 		}
 	}
 
@@ -120,23 +1000,491 @@ func expandValue(str string, values VariableLookup) (interface{}, error) {
 		return str, nil
 	}
 
+	var formatted interface{} = *value
+	for _, m := range p.modifiers {
+		if m.valueOnly && usedFallback {
+			continue
+		}
+		input := formatted
+		formatted, err = applyModifier(input, m.name, m.arg, options)
+		if err != nil {
+			err = customFormatError(err, name, m.name, input, options)
+			if options.Redact || options.sensitive {
+				return nil, fmt.Errorf("variable %s: %w", name, err)
+			}
+			return nil, err
+		}
+		options.notify(Event{Kind: EventFormat, Name: name, Format: m.name, Value: formatted})
+	}
+	return formatted, nil
+}
+
+// transitiveLookup expands placeholders in the values returned by values
+// with the same lookup. The names currently being resolved are tracked in
+// stack to detect cycles.
+func transitiveLookup(values VariableLookup, options Options, stack []string) VariableLookup {
+	return func(key string) (*string, error) {
+		for i, name := range stack {
+			if name == key {
+				return nil, fmt.Errorf("variable cycle detected: %s", strings.Join(append(stack[i:], key), " -> "))
+			}
+		}
+		value, err := values(key)
+		if err != nil || value == nil || !hasPlaceholders(*value) {
+			return value, err
+		}
+		nestedOptions := options
+		nestedOptions.Transitive = false
+		nestedOptions.OutputEscaping = OutputEscapingNone
+		nestedOptions.placeholders = nil
+		nestedOptions.leaves = nil
+		nestedStack := append(append([]string{}, stack...), key)
+		expanded, err := ExpandWithOptions(*value, transitiveLookup(values, options, nestedStack), nestedOptions)
+		if err != nil {
+			return nil, err
+		}
+		str := stringify(expanded)
+		return &str, nil
+	}
+}
+
+// lookupSeed resolves the variable given by the seed modifier.
+func lookupSeed(name string, values VariableLookup, options Options) (string, error) {
+	name, err := mapName(name, options)
+	if err != nil {
+		return "", err
+	}
+	options.notify(Event{Kind: EventReference, Name: name})
+	value, err := values(name)
+	if err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "", fmt.Errorf("seed variable %s cannot be resolved", name)
+	}
+	return *value, nil
+}
+
+// coalesceLookup resolves a variable to the first present and non-empty
+// value among itself and the alternatives. If there is none, the result of
+// the variable itself is returned. With a fallback, empty values are
+// treated as missing, so the fallback applies.
+func coalesceLookup(values VariableLookup, alternatives []string, hasFallback bool, options Options) (VariableLookup, error) {
+	names := make([]string, len(alternatives))
+	for i, alternative := range alternatives {
+		name, err := mapName(alternative, options)
+		if err != nil {
+			return nil, err
+		}
+		names[i] = name
+	}
+	return func(key string) (*string, error) {
+		value, err := values(key)
+		if err == nil && value != nil && *value != "" {
+			return value, nil
+		}
+		for _, name := range names {
+			options.notify(Event{Kind: EventReference, Name: name})
+			alternative, altErr := values(name)
+			if altErr == nil && alternative != nil && *alternative != "" {
+				return alternative, nil
+			}
+		}
+		if err == nil && value != nil && hasFallback {
+			return nil, fmt.Errorf("variable %s is empty", key)
+		}
+		return value, err
+	}, nil
+}
+
+// indirectLookup resolves a variable to the name of another variable and
+// returns the value of that one, as ${!REF} does.
+func indirectLookup(values VariableLookup, options Options) VariableLookup {
+	return func(key string) (*string, error) {
+		target, err := values(key)
+		if err != nil || target == nil {
+			return target, err
+		}
+		name, err := mapName(*target, options)
+		if err != nil {
+			return nil, fmt.Errorf("indirection via %s: %w", key, err)
+		}
+		value, err := values(name)
+		if err != nil {
+			return nil, fmt.Errorf("indirection via %s: %w", key, err)
+		}
+		return value, nil
+	}
+}
+
+// mapName applies the NameMapper and checks whether the resulting name may
+// be looked up.
+func mapName(name string, options Options) (string, error) {
+	if options.NameMapper != nil {
+		name = options.NameMapper(name)
+	}
+	if err := checkPermitted(name, options); err != nil {
+		return name, err
+	}
+	return name, nil
+}
+
+func checkPermitted(name string, options Options) error {
+	denied, err := matchesAny(name, options.DenyNames)
+	if err != nil {
+		return err
+	}
+	allowed := true
+	if options.AllowNames != nil {
+		allowed, err = matchesAny(name, options.AllowNames)
+		if err != nil {
+			return err
+		}
+	}
+	if denied || !allowed {
+		return fmt.Errorf("variable %s is not permitted", name)
+	}
+	return nil
+}
+
+func matchesAny(name string, patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("pattern %s is invalid", pattern)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applyModifier applies a format to the result of the previous one. Only
+// clamp works on numbers, all other formats need a string.
+func applyModifier(value interface{}, format string, formatArg string, options Options) (interface{}, error) {
+	if format == "clamp" {
+		return clampNumber(value, formatArg, options)
+	}
+	input, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("format %s needs a string input", format)
+	}
+	return formatValue(input, format, formatArg, options)
+}
+
+// clampNumber constrains a number produced by the number format into the
+// range lo..hi. Integers are clamped to the integers within the range.
+func clampNumber(value interface{}, rangeArg string, options Options) (interface{}, error) {
+	lo, hi, err := parseFloatRange(rangeArg)
+	if err != nil {
+		return nil, err
+	}
+	clamp := func(f float64) float64 {
+		return math.Min(math.Max(f, lo), hi)
+	}
+	switch value := value.(type) {
+	case int:
+		clamped, err := clampInt(int64(value), rangeArg)
+		if err != nil {
+			return nil, err
+		}
+		return int(clamped), nil
+	case int64:
+		return clampInt(value, rangeArg)
+	case float64:
+		return clamp(value), nil
+	case FormattedFloat:
+		clamped := clamp(value.Value)
+		if clamped == value.Value {
+			return value, nil
+		}
+		return FormattedFloat{
+			Value: clamped,
+			Text:  strconv.FormatFloat(clamped, options.FloatFormat.Format, options.FloatFormat.Precision, 64),
+		}, nil
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			clamped, err := clampInt(i, rangeArg)
+			if err != nil {
+				return nil, err
+			}
+			return json.Number(strconv.FormatInt(clamped, 10)), nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("format clamp needs a number input")
+		}
+		return clamp(f), nil
+	}
+	return nil, fmt.Errorf("format clamp needs a number input")
+}
+
+// clampInt clamps an integer to the integers within the range. Bounds are
+// compared as int64, so integers beyond 2^53 keep their precision.
+func clampInt(value int64, rangeArg string) (int64, error) {
+	lo, hi, _ := strings.Cut(rangeArg, "..")
+	ilo, ihi := intBound(lo, math.Ceil), intBound(hi, math.Floor)
+	if ilo > ihi {
+		return 0, fmt.Errorf("range %s does not contain an integer", rangeArg)
+	}
+	if value < ilo {
+		return ilo, nil
+	}
+	if value > ihi {
+		return ihi, nil
+	}
+	return value, nil
+}
+
+// intBound parses a range bound that has already been validated as a float.
+// Non-integer bounds are rounded with round and saturate at the int64 limits.
+func intBound(str string, round func(float64) float64) int64 {
+	if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return i
+	}
+	f, _ := strconv.ParseFloat(str, 64)
+	f = round(f)
+	if f >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	if f <= math.MinInt64 {
+		return math.MinInt64
+	}
+	return int64(f)
+}
+
+func parseFloatRange(str string) (float64, float64, error) {
+	parts := strings.SplitN(str, "..", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("range %s is invalid", str)
+	}
+	lo, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("range %s is invalid", str)
+	}
+	hi, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || math.IsNaN(lo) || math.IsNaN(hi) || lo > hi {
+		return 0, 0, fmt.Errorf("range %s is invalid", str)
+	}
+	return lo, hi, nil
+}
+
+// defaultMaxDepth is the nesting depth used if Options.MaxDepth is not set.
+const defaultMaxDepth = 1000
+
+// numericStringRegex matches plain decimal numbers like 007 or -1.5, unlike
+// strconv.ParseFloat, which also accepts NaN, Inf, exponents and hex floats.
+var numericStringRegex = regexp.MustCompile(`^[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)$`)
+
+// maxRepeat bounds the repeat format to avoid huge allocations.
+const maxRepeat = 10000
+
+// maxRepeatOutput bounds the output of the repeat, pad and padleft formats
+// in bytes, as chaining them multiplies the length.
+const maxRepeatOutput = 1 << 20
+
+func formatValue(value string, format string, formatArg string, options Options) (interface{}, error) {
+	formatted, err := applyFormat(value, format, formatArg, options)
+	if err != nil || !options.JSONSafe {
+		return formatted, err
+	}
+	safe, ok := jsonSafe(formatted)
+	if !ok {
+		return nil, fmt.Errorf("format %s produces %T, which cannot be represented in JSON", format, formatted)
+	}
+	return safe, nil
+}
+
+func applyFormat(value string, format string, formatArg string, options Options) (interface{}, error) {
+	display := value
+	if options.Redact || options.sensitive {
+		display = "***"
+	}
+	if fn, ok := options.Formats[format]; ok {
+		if formatArg != "" {
+			return nil, fmt.Errorf("format %s does not take options", format)
+		}
+		formatted, err := fn(value)
+		if err != nil && display != value && value != "" {
+			// Custom formats cannot know about redaction, so the value is
+			// removed from their message instead.
+			return nil, &formatError{message: strings.ReplaceAll(err.Error(), value, display), err: err}
+		}
+		return formatted, err
+	}
 	switch format {
 	case "":
-		return *value, nil
+		return value, nil
 	case "string":
-		return *value, nil
+		return value, nil
 	case "number":
-		formatted, err := strconv.Atoi(*value)
+		formatted, err := strconv.Atoi(value)
 		if err != nil {
-			formatted, err := strconv.ParseFloat(*value, 64)
+			formatted, err := strconv.ParseFloat(value, 64)
 			if err != nil {
-				return nil, fmt.Errorf("%s is not a valid number", *value)
+				return nil, fmt.Errorf("%s is not a valid number", display)
+			}
+			if options.FloatFormat.Format != 0 {
+				return FormattedFloat{
+					Value: formatted,
+					Text:  strconv.FormatFloat(formatted, options.FloatFormat.Format, options.FloatFormat.Precision, 64),
+				}, nil
 			}
 			return formatted, nil
 		}
 		return formatted, nil
+	case "numeric-string":
+		if !numericStringRegex.MatchString(value) {
+			return nil, fmt.Errorf("%s is not a valid number", display)
+		}
+		return value, nil
+	case "count":
+		formatted, err := strconv.Atoi(value)
+		if err != nil || formatted < 0 {
+			return nil, fmt.Errorf("%s is not a valid count", display)
+		}
+		if formatArg != "" {
+			from, to, err := parseRange(formatArg)
+			if err != nil {
+				return nil, err
+			}
+			if formatted < from || formatted > to {
+				return nil, fmt.Errorf("%s is not within %d..%d", display, from, to)
+			}
+		}
+		return formatted, nil
+	case "base64encode":
+		formatted := base64.StdEncoding.EncodeToString([]byte(value))
+		if formatArg != "" {
+			width, err := strconv.Atoi(strings.TrimPrefix(formatArg, "wrap"))
+			if !strings.HasPrefix(formatArg, "wrap") || err != nil || width <= 0 {
+				return nil, fmt.Errorf("base64encode option %s is invalid", formatArg)
+			}
+			lines := []string{}
+			for len(formatted) > width {
+				lines = append(lines, formatted[:width])
+				formatted = formatted[width:]
+			}
+			formatted = strings.Join(append(lines, formatted), "\n")
+		}
+		return formatted, nil
+	case "json-escape":
+		buf := bytes.Buffer{}
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(value); err != nil {
+			return nil, err
+		}
+		encoded := strings.TrimSuffix(buf.String(), "\n")
+		return encoded[1 : len(encoded)-1], nil
+	case "trim":
+		return strings.TrimSpace(value), nil
+	case "lower":
+		return strings.ToLower(value), nil
+	case "upper":
+		return strings.ToUpper(value), nil
+	case "shellquote":
+		return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'", nil
+	case "hash":
+		var sum []byte
+		switch formatArg {
+		case "sha256":
+			hashed := sha256.Sum256([]byte(value))
+			sum = hashed[:]
+		case "sha1":
+			hashed := sha1.Sum([]byte(value))
+			sum = hashed[:]
+		case "md5":
+			hashed := md5.Sum([]byte(value))
+			sum = hashed[:]
+		case "":
+			return nil, fmt.Errorf("hash needs an algorithm like hash=sha256")
+		default:
+			return nil, fmt.Errorf("hash option %s is invalid", formatArg)
+		}
+		return hex.EncodeToString(sum), nil
+	case "trimprefix":
+		return strings.TrimPrefix(value, formatArg), nil
+	case "trimsuffix":
+		return strings.TrimSuffix(value, formatArg), nil
+	case "repeat":
+		count, err := strconv.Atoi(formatArg)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("repeat count %s is invalid", formatArg)
+		}
+		if count > maxRepeat {
+			return nil, fmt.Errorf("repeat count %d exceeds the maximum of %d", count, maxRepeat)
+		}
+		if size := len(value) * count; size > maxRepeatOutput {
+			return nil, fmt.Errorf("repeat output of %d bytes exceeds the maximum of %d", size, maxRepeatOutput)
+		}
+		return strings.Repeat(value, count), nil
+	case "pad", "padleft":
+		widthArg, fill, hasFill := strings.Cut(formatArg, ":")
+		width, err := strconv.Atoi(widthArg)
+		if err != nil || width < 0 {
+			return nil, fmt.Errorf("pad width %s is invalid", widthArg)
+		}
+		if width > maxRepeat {
+			return nil, fmt.Errorf("pad width %d exceeds the maximum of %d", width, maxRepeat)
+		}
+		if !hasFill {
+			fill = " "
+		}
+		if utf8.RuneCountInString(fill) != 1 {
+			return nil, fmt.Errorf("pad fill %s must be a single character", fill)
+		}
+		missing := width - utf8.RuneCountInString(value)
+		if missing <= 0 {
+			return value, nil
+		}
+		if size := len(value) + missing*len(fill); size > maxRepeatOutput {
+			return nil, fmt.Errorf("pad output of %d bytes exceeds the maximum of %d", size, maxRepeatOutput)
+		}
+		if format == "padleft" {
+			return strings.Repeat(fill, missing) + value, nil
+		}
+		return value + strings.Repeat(fill, missing), nil
+	case "bytesize":
+		size, ok := parseByteSize(value)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a valid byte size", display)
+		}
+		return size, nil
+	case "percent":
+		if options.seed == nil {
+			return nil, fmt.Errorf("format percent needs a seed like percent:seed=USER")
+		}
+		percent, ok := parsePercent(value)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a valid percentage", display)
+		}
+		return inRollout(*options.seed, percent), nil
+	case "first", "last", "rest":
+		if strings.TrimSpace(value) == "" {
+			return "", nil
+		}
+		items := strings.Split(value, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		switch format {
+		case "first":
+			return items[0], nil
+		case "last":
+			return items[len(items)-1], nil
+		default:
+			return strings.Join(items[1:], ","), nil
+		}
+	case "semver":
+		normalized, ok := normalizeSemver(value)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a valid semver", display)
+		}
+		return normalized, nil
 	case "boolean":
-		switch *value {
+		switch value {
 		case "0":
 			return false, nil
 		case "1":
@@ -150,9 +1498,53 @@ func expandValue(str string, values VariableLookup) (interface{}, error) {
 		case "yes":
 			return true, nil
 		default:
-			return nil, fmt.Errorf("%s is not a valid boolean", *value)
+			return nil, fmt.Errorf("%s is not a valid boolean", display)
 		}
 	default:
 		return nil, fmt.Errorf("format %s is not supported", format)
 	}
 }
+
+func parseRange(str string) (int, int, error) {
+	parts := strings.SplitN(str, "..", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("range %s is invalid", str)
+	}
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("range %s is invalid", str)
+	}
+	to, err := strconv.Atoi(parts[1])
+	if err != nil || to < from {
+		return 0, 0, fmt.Errorf("range %s is invalid", str)
+	}
+	return from, to, nil
+}
+
+func readFallbackFile(fallback string, dir string) (string, error) {
+	if strings.HasPrefix(fallback, "@@") {
+		return fallback[1:], nil
+	}
+	if !strings.HasPrefix(fallback, "@") {
+		return fallback, nil
+	}
+	file := fallback[1:]
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("fallback file %s is missing", fallback[1:])
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func expandShellEnv(str string) string {
+	regex := regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
+	return regex.ReplaceAllStringFunc(str, func(ref string) string {
+		return os.Getenv(ref[1:])
+	})
+}
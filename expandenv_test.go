@@ -1,9 +1,16 @@
 package expandenv
 
 import (
+	"errors"
 	"fmt"
+	"html/template"
 	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,6 +32,39 @@ func TestExpand(t *testing.T) {
 		case "FN_42_5":
 			result := "42.5"
 			return &result, nil
+		case "FN_MINUS_1":
+			result := "-1"
+			return &result, nil
+		case "FN_SPECIAL":
+			result := "say \"hi\"\\\n\t<b>"
+			return &result, nil
+		case "FN_SHELL":
+			result := "it's $HOME and more"
+			return &result, nil
+		case "FN_URL":
+			result := "https://example.com/"
+			return &result, nil
+		case "FN_DASH":
+			result := "-"
+			return &result, nil
+		case "FN_0":
+			result := "0"
+			return &result, nil
+		case "FN_70000":
+			result := "70000"
+			return &result, nil
+		case "FN_BIG":
+			result := "9007199254740995"
+			return &result, nil
+		case "FN_CODE":
+			result := "007"
+			return &result, nil
+		case "FN_CSV":
+			result := " a, b ,c "
+			return &result, nil
+		case "FN_EMPTY":
+			result := ""
+			return &result, nil
 		case "FN_YES":
 			result := "yes"
 			return &result, nil
@@ -130,6 +170,340 @@ func TestExpand(t *testing.T) {
 			label:  "variabled-format-2",
 			error:  fmt.Errorf("42 is not a valid boolean"),
 		},
+		{
+			input:  "${FN_42:count}",
+			output: 42,
+			label:  "variabled-format-count",
+		},
+		{
+			input:  "${FN_42:count=1..100}",
+			output: 42,
+			label:  "variabled-format-count-2",
+		},
+		{
+			input:  "${FN_MINUS_1:count}",
+			output: "${FN_MINUS_1:count}",
+			label:  "variabled-format-count-negative",
+			error:  fmt.Errorf("-1 is not a valid count"),
+		},
+		{
+			input:  "${FN_42_5:count}",
+			output: "${FN_42_5:count}",
+			label:  "variabled-format-count-float",
+			error:  fmt.Errorf("42.5 is not a valid count"),
+		},
+		{
+			input:  "${FN_42:count=1..5}",
+			output: "${FN_42:count=1..5}",
+			label:  "variabled-format-count-out-of-range",
+			error:  fmt.Errorf("42 is not within 1..5"),
+		},
+		{
+			input:  "${FN_42:count=5..1}",
+			output: "${FN_42:count=5..1}",
+			label:  "variabled-format-count-invalid-range",
+			error:  fmt.Errorf("range 5..1 is invalid"),
+		},
+		{
+			input:  "${FN_MULTI_LINE:base64encode}",
+			output: "bGluZTEKbGluZTI=",
+			label:  "variabled-format-base64encode",
+		},
+		{
+			input:  "${FN_MULTI_LINE:base64encode=wrap4}",
+			output: "bGlu\nZTEK\nbGlu\nZTI=",
+			label:  "variabled-format-base64encode-wrap",
+		},
+		{
+			input:  "${FN_MULTI_LINE:base64encode=wrap16}",
+			output: "bGluZTEKbGluZTI=",
+			label:  "variabled-format-base64encode-wrap-2",
+		},
+		{
+			input:  "${FN_MULTI_LINE:base64encode=wrap}",
+			output: "${FN_MULTI_LINE:base64encode=wrap}",
+			label:  "variabled-format-base64encode-invalid",
+			error:  fmt.Errorf("base64encode option wrap is invalid"),
+		},
+		{
+			input:  "{\"value\": \"${FN_SPECIAL:json-escape}\"}",
+			output: "{\"value\": \"say \\\"hi\\\"\\\\\\n\\t<b>\"}",
+			label:  "variabled-format-json-escape",
+		},
+		{
+			input:  "${FN_A:json-escape}",
+			output: "a",
+			label:  "variabled-format-json-escape-2",
+		},
+		{
+			input:  "echo ${FN_SHELL:shellquote}",
+			output: "echo 'it'\\''s $HOME and more'",
+			label:  "variabled-format-shellquote",
+		},
+		{
+			input:  "${FN_A:shellquote}",
+			output: "'a'",
+			label:  "variabled-format-shellquote-2",
+		},
+		{
+			input:  "${FN_A:hash=sha256}",
+			output: "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+			label:  "variabled-format-hash-sha256",
+		},
+		{
+			input:  "${FN_A:hash=sha1}",
+			output: "86f7e437faa5a7fce15d1ddcb9eaeaea377667b8",
+			label:  "variabled-format-hash-sha1",
+		},
+		{
+			input:  "cache-${FN_A:hash=md5}",
+			output: "cache-0cc175b9c0f1b6a831c399e269772661",
+			label:  "variabled-format-hash-md5",
+		},
+		{
+			input:  "${FN_UNKNOWN:hash=sha256:-}",
+			output: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			label:  "variabled-format-hash-fallback",
+		},
+		{
+			input:  "${FN_A:hash=sha512}",
+			output: "${FN_A:hash=sha512}",
+			label:  "variabled-format-hash-invalid",
+			error:  fmt.Errorf("hash option sha512 is invalid"),
+		},
+		{
+			input:  "${FN_A:hash}",
+			output: "${FN_A:hash}",
+			label:  "variabled-format-hash-missing",
+			error:  fmt.Errorf("hash needs an algorithm like hash=sha256"),
+		},
+		{
+			input:  "${FN_URL:trimprefix=https://}",
+			output: "example.com/",
+			label:  "variabled-format-trimprefix",
+		},
+		{
+			input:  "${FN_URL:trimprefix=ftp://}",
+			output: "https://example.com/",
+			label:  "variabled-format-trimprefix-absent",
+		},
+		{
+			input:  "${FN_URL:trimsuffix=/}",
+			output: "https://example.com",
+			label:  "variabled-format-trimsuffix",
+		},
+		{
+			input:  "${FN_URL:trimsuffix=.org}",
+			output: "https://example.com/",
+			label:  "variabled-format-trimsuffix-absent",
+		},
+		{
+			input:  "${FN_URL:trimprefix=https://:trimsuffix=/:upper}",
+			output: "EXAMPLE.COM",
+			label:  "variabled-format-chain",
+		},
+		{
+			input:  "${FN_URL:trimprefix=https\\://example.com}",
+			output: "/",
+			label:  "variabled-format-escaped-colon",
+		},
+		{
+			input:  "${FN_URL:trimsuffix=com/\\:-x:-y}",
+			output: "https://example.com/",
+			label:  "variabled-format-escaped-fallback",
+		},
+		{
+			input:  "${FN_URL:trimprefix=https:example}",
+			output: "${FN_URL:trimprefix=https:example}",
+			label:  "variabled-format-unescaped-colon",
+			error:  fmt.Errorf("format example is not supported"),
+		},
+		{
+			input:  "${FN_UNKNOWN:trimprefix=https://:trimsuffix=/:-https://fallback.com/}",
+			output: "fallback.com",
+			label:  "variabled-format-chain-fallback",
+		},
+		{
+			input:  "${FN_42:number:trim}",
+			output: "${FN_42:number:trim}",
+			label:  "variabled-format-chain-no-string",
+			error:  fmt.Errorf("format trim needs a string input"),
+		},
+		{
+			input:  "${FN_A:lower:Upper}",
+			output: "${FN_A:lower:Upper}",
+			label:  "variabled-format-chain-invalid",
+			error:  fmt.Errorf("could not parse ${FN_A:lower:Upper}"),
+		},
+		{
+			input:  "${FN_DASH:repeat=10}",
+			output: "----------",
+			label:  "variabled-format-repeat",
+		},
+		{
+			input:  "[${FN_DASH:repeat=0}]",
+			output: "[]",
+			label:  "variabled-format-repeat-zero",
+		},
+		{
+			input:  "${FN_DASH:repeat=-1}",
+			output: "${FN_DASH:repeat=-1}",
+			label:  "variabled-format-repeat-negative",
+			error:  fmt.Errorf("repeat count -1 is invalid"),
+		},
+		{
+			input:  "${FN_DASH:repeat=1000000}",
+			output: "${FN_DASH:repeat=1000000}",
+			label:  "variabled-format-repeat-too-large",
+			error:  fmt.Errorf("repeat count 1000000 exceeds the maximum of 10000"),
+		},
+		{
+			input:  "${FN_DASH:repeat=10000:repeat=10000}",
+			output: "${FN_DASH:repeat=10000:repeat=10000}",
+			label:  "variabled-format-repeat-output-too-large",
+			error:  fmt.Errorf("repeat output of 100000000 bytes exceeds the maximum of 1048576"),
+		},
+		{
+			input:  "${FN_0:number:clamp=1..65535}",
+			output: 1,
+			label:  "variabled-format-clamp-below",
+		},
+		{
+			input:  "${FN_42:number:clamp=1..65535}",
+			output: 42,
+			label:  "variabled-format-clamp-within",
+		},
+		{
+			input:  "${FN_70000:number:clamp=1..65535}",
+			output: 65535,
+			label:  "variabled-format-clamp-above",
+		},
+		{
+			input:  "${FN_42_5:number:clamp=0..1.5}",
+			output: 1.5,
+			label:  "variabled-format-clamp-float",
+		},
+		{
+			input:  "${FN_42:number:clamp=0.5..10.5}",
+			output: 10,
+			label:  "variabled-format-clamp-int-with-float-range",
+		},
+		{
+			input:  "${FN_BIG:number:clamp=0..9007199254740995}",
+			output: 9007199254740995,
+			label:  "variabled-format-clamp-big-within",
+		},
+		{
+			input:  "${FN_BIG:number:clamp=0..9007199254740993}",
+			output: 9007199254740993,
+			label:  "variabled-format-clamp-big-above",
+		},
+		{
+			input:  "${FN_42:clamp=1..10}",
+			output: "${FN_42:clamp=1..10}",
+			label:  "variabled-format-clamp-string",
+			error:  fmt.Errorf("format clamp needs a number input"),
+		},
+		{
+			input:  "${FN_42:number:clamp=10..1}",
+			output: "${FN_42:number:clamp=10..1}",
+			label:  "variabled-format-clamp-invalid",
+			error:  fmt.Errorf("range 10..1 is invalid"),
+		},
+		{
+			input:  "[${FN_42:pad=5}][${FN_42:padleft=5}][${FN_42:padleft=5:0}][${FN_42:pad=4:.}]",
+			output: "[42   ][   42][00042][42..]",
+			label:  "variabled-format-pad",
+		},
+		{
+			input:  "[${FN_CODE:pad=3}][${FN_CODE:padleft=3:0}][${FN_CSV:pad=2}][${FN_CSV:padleft=0}]",
+			output: "[007][007][ a, b ,c ][ a, b ,c ]",
+			label:  "variabled-format-pad-not-shorter",
+		},
+		{
+			input:  "${FN_42:padleft=5:00}",
+			output: "${FN_42:padleft=5:00}",
+			error:  fmt.Errorf("pad fill 00 must be a single character"),
+			label:  "variabled-format-pad-invalid-fill",
+		},
+		{
+			input:  "${FN_42:pad=x}",
+			output: "${FN_42:pad=x}",
+			error:  fmt.Errorf("pad width x is invalid"),
+			label:  "variabled-format-pad-invalid-width",
+		},
+		{
+			input:  "${FN_UNKNOWN:coalesce=FN_A,FN_42}",
+			output: "a",
+			label:  "variabled-coalesce-second",
+		},
+		{
+			input:  "${FN_EMPTY:coalesce=FN_UNKNOWN, FN_42:number}",
+			output: 42,
+			label:  "variabled-coalesce-empty",
+		},
+		{
+			input:  "${FN_A:coalesce=FN_42}",
+			output: "a",
+			label:  "variabled-coalesce-first",
+		},
+		{
+			input:  "${FN_UNKNOWN:coalesce=FN_EMPTY:-x}",
+			output: "x",
+			label:  "variabled-coalesce-fallback",
+		},
+		{
+			input:  "${FN_UNKNOWN:coalesce=FN_EMPTY}",
+			output: "${FN_UNKNOWN:coalesce=FN_EMPTY}",
+			error:  fmt.Errorf("unknown"),
+			label:  "variabled-coalesce-missing",
+		},
+		{
+			input:  "${FN_EMPTY:coalesce=FN_UNKNOWN:-x}",
+			output: "x",
+			label:  "variabled-coalesce-empty-fallback",
+		},
+		{
+			input:  "${FN_EMPTY:coalesce=FN_UNKNOWN}",
+			output: "",
+			label:  "variabled-coalesce-all-empty",
+		},
+		{
+			input:  "${FN_CODE:numeric-string}",
+			output: "007",
+			label:  "variabled-format-numeric-string",
+		},
+		{
+			input:  "${FN_CODE:number}",
+			output: 7,
+			label:  "variabled-format-numeric-string-number",
+		},
+		{
+			input:  "${FN_A:numeric-string}",
+			output: "${FN_A:numeric-string}",
+			error:  fmt.Errorf("a is not a valid number"),
+			label:  "variabled-format-numeric-string-invalid",
+		},
+		{
+			input:  "${FN_CSV:first}|${FN_CSV:last}|${FN_CSV:rest}",
+			output: "a|c|b,c",
+			label:  "variabled-format-first-last-rest",
+		},
+		{
+			input:  "${FN_A:first}|${FN_A:last}|${FN_A:rest}",
+			output: "a|a|",
+			label:  "variabled-format-first-last-rest-single",
+		},
+		{
+			input:  "${FN_EMPTY:first}|${FN_EMPTY:last}|${FN_EMPTY:rest}",
+			output: "||",
+			label:  "variabled-format-first-last-rest-empty",
+		},
+		{
+			input:  "${FN_CSV:last:upper}",
+			output: "C",
+			label:  "variabled-format-last-chain",
+		},
 		{
 			input:  "foo: some ${FN_A} ${FN_UNKNOWN}",
 			output: "foo: some a ${FN_UNKNOWN}",
@@ -151,6 +525,36 @@ func TestExpand(t *testing.T) {
 			output: "foo: some a ||",
 			label:  "variabled-fallback-2",
 		},
+		{
+			input:  "${FN_UNKNOWN:-[]}",
+			output: []interface{}{},
+			label:  "variabled-fallback-empty-list",
+		},
+		{
+			input:  "${FN_UNKNOWN:-{}}",
+			output: map[string]interface{}{},
+			label:  "variabled-fallback-empty-map",
+		},
+		{
+			input:  "${FN_A:-{}}",
+			output: "a",
+			label:  "variabled-fallback-empty-map-unused",
+		},
+		{
+			input:  "list ${FN_UNKNOWN:-[]} map ${FN_UNKNOWN:-{}}",
+			output: "list [] map {}",
+			label:  "variabled-fallback-empty-embedded",
+		},
+		{
+			input:  "${FN_UNKNOWN:string:-[]}",
+			output: "[]",
+			label:  "variabled-fallback-empty-string",
+		},
+		{
+			input:  "${FN_UNKNOWN:-{a}} ${FN_UNKNOWN:-x{}",
+			output: "{a} x{",
+			label:  "variabled-fallback-braces",
+		},
 		{
 			input:  "foo: ${FN_IGNORE}",
 			output: "foo: ${FN_IGNORE}",
@@ -213,6 +617,7 @@ func TestExpandMap(t *testing.T) {
 func TestExpandEnv(t *testing.T) {
 	os.Setenv("ENV_A", "a")
 	os.Setenv("ENV_B", "b")
+	os.Setenv("ENV_DEFAULT_PORT", "8080")
 
 	testCases := []struct {
 		input  interface{}
@@ -236,6 +641,32 @@ func TestExpandEnv(t *testing.T) {
 			label:  "variabled-unknown",
 			error:  fmt.Errorf("environment variable ENV_UNKNOWN is missing"),
 		},
+		{
+			input:  "${ENV_PORT:-${ENV_DEFAULT_PORT}}",
+			output: "8080",
+			label:  "variabled-nested-fallback",
+		},
+		{
+			input:  "${ENV_PORT:number:-${ENV_DEFAULT_PORT}}",
+			output: 8080,
+			label:  "variabled-nested-fallback-format",
+		},
+		{
+			input:  "host:${ENV_PORT:-${ENV_UNKNOWN_PORT:-${ENV_DEFAULT_PORT}}}",
+			output: "host:8080",
+			label:  "variabled-nested-fallback-2",
+		},
+		{
+			input:  "${ENV_PORT:-${ENV_UNKNOWN_PORT}}",
+			output: "${ENV_PORT:-${ENV_UNKNOWN_PORT}}",
+			label:  "variabled-nested-fallback-unknown",
+			error:  fmt.Errorf("environment variable ENV_UNKNOWN_PORT is missing"),
+		},
+		{
+			input:  "${ENV_PORT:-\\${ENV_DEFAULT_PORT}}",
+			output: "${ENV_DEFAULT_PORT}",
+			label:  "variabled-nested-fallback-escaped",
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -270,7 +701,7 @@ g: \\${MAP_ESCAPED}
 	err := yaml.Unmarshal(yamlBytes, &yamlRaw)
 	assert.NoError(t, err)
 	yamlRaw, err = ExpandMap(yamlRaw, values)
-	assert.EqualError(t, err, "variable MAP_UNKNOWN is missing")
+	assert.EqualError(t, err, "at e: variable MAP_UNKNOWN is missing")
 	yamlBytes, err = yaml.Marshal(yamlRaw)
 	assert.NoError(t, err)
 	assert.Equal(t, `a: a
@@ -286,3 +717,1262 @@ f: ${MAP_ESCAPED}
 g: \${MAP_ESCAPED}
 `, string(yamlBytes))
 }
+
+func TestExpandWithNameMapper(t *testing.T) {
+	values := map[string]string{
+		"DB_HOST": "localhost",
+		"DB_PORT": "5432",
+	}
+	options := Options{
+		NameMapper: func(name string) string {
+			return strings.ReplaceAll(strings.ToUpper(name), "-", "_")
+		},
+	}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  "${db-host}",
+			output: "localhost",
+			label:  "mapped",
+		},
+		{
+			input:  "${DB_HOST}:${db-port}",
+			output: "localhost:5432",
+			label:  "mapped-embedded",
+		},
+		{
+			input:  "${db-port:number}",
+			output: 5432,
+			label:  "mapped-format",
+		},
+		{
+			input:  "${db-user:-admin}",
+			output: "admin",
+			label:  "mapped-fallback",
+		},
+		{
+			input:  "${db-user}",
+			output: "${db-user}",
+			label:  "mapped-unknown",
+			error:  fmt.Errorf("variable DB_USER is missing"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandWithOptions(testCase.input, func(key string) (*string, error) {
+			value, ok := values[key]
+			if !ok {
+				return nil, fmt.Errorf("variable %s is missing", key)
+			}
+			return &value, nil
+		}, options)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+}
+
+func TestExpandWithFailFast(t *testing.T) {
+	lookups := 0
+	values := func(key string) (*string, error) {
+		lookups++
+		if key == "FN_A" {
+			result := "a"
+			return &result, nil
+		}
+		return nil, fmt.Errorf("variable %s is missing", key)
+	}
+	input := []interface{}{
+		"${FN_A}",
+		"${FN_UNKNOWN_1} ${FN_UNKNOWN_2}",
+		"${FN_UNKNOWN_3}",
+		map[string]interface{}{"a": "${FN_UNKNOWN_4}"},
+	}
+
+	output, err := ExpandWithOptions(input, values, Options{FailFast: true})
+	assert.EqualError(t, err, "at [1]: variable FN_UNKNOWN_1 is missing")
+	assert.Equal(t, []interface{}{
+		"a",
+		"${FN_UNKNOWN_1} ${FN_UNKNOWN_2}",
+		"${FN_UNKNOWN_3}",
+		map[string]interface{}{"a": "${FN_UNKNOWN_4}"},
+	}, output)
+	assert.Equal(t, 2, lookups)
+
+	lookups = 0
+	_, err = ExpandWithOptions(input, values, Options{})
+	assert.EqualError(t, err, "at [1]: variable FN_UNKNOWN_1 is missing, at [1]: variable FN_UNKNOWN_2 is missing, at [2]: variable FN_UNKNOWN_3 is missing, at [3].a: variable FN_UNKNOWN_4 is missing")
+	assert.Equal(t, 5, lookups)
+}
+
+func TestExpandWithFallbackEnv(t *testing.T) {
+	t.Setenv("FALLBACK_HOME", "/home/user")
+	values := func(key string) (*string, error) {
+		return nil, fmt.Errorf("variable %s is missing", key)
+	}
+
+	testCases := []struct {
+		input   interface{}
+		output  interface{}
+		options Options
+		label   string
+	}{
+		{
+			input:   "${CONFIG:-$FALLBACK_HOME/config}",
+			output:  "/home/user/config",
+			options: Options{ExpandFallbackEnv: true},
+			label:   "enabled",
+		},
+		{
+			input:   "path=${CONFIG:-$FALLBACK_HOME/config}",
+			output:  "path=/home/user/config",
+			options: Options{ExpandFallbackEnv: true},
+			label:   "enabled-embedded",
+		},
+		{
+			input:   "${CONFIG:-$FALLBACK_UNKNOWN/config}",
+			output:  "/config",
+			options: Options{ExpandFallbackEnv: true},
+			label:   "enabled-unknown",
+		},
+		{
+			input:   "${CONFIG:-$FALLBACK_HOME/config}",
+			output:  "$FALLBACK_HOME/config",
+			options: Options{},
+			label:   "disabled",
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandWithOptions(testCase.input, values, testCase.options)
+		assert.NoError(t, err, testCase.label)
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+}
+
+func TestMustExpand(t *testing.T) {
+	os.Setenv("ENV_A", "a")
+	values := func(key string) (*string, error) {
+		if key == "FN_A" {
+			result := "a"
+			return &result, nil
+		}
+		return nil, fmt.Errorf("unknown")
+	}
+
+	assert.Equal(t, "a", MustExpand("${FN_A}", values))
+	assert.PanicsWithError(t, "unknown", func() { MustExpand("${FN_UNKNOWN}", values) })
+	assert.Equal(t, "a", MustExpandMap("${MAP_A}", map[string]string{"MAP_A": "a"}))
+	assert.PanicsWithError(t, "variable MAP_UNKNOWN is missing", func() { MustExpandMap("${MAP_UNKNOWN}", map[string]string{}) })
+	assert.Equal(t, "a", MustExpandEnv("${ENV_A}"))
+	assert.PanicsWithError(t, "environment variable ENV_UNKNOWN is missing", func() { MustExpandEnv("${ENV_UNKNOWN}") })
+}
+
+func TestExpandWithFallbackFiles(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "default.yaml"), []byte("key: value\n"), 0o644)
+	assert.NoError(t, err)
+	values := func(key string) (*string, error) {
+		return nil, fmt.Errorf("variable %s is missing", key)
+	}
+
+	testCases := []struct {
+		input   interface{}
+		output  interface{}
+		options Options
+		label   string
+		error   error
+	}{
+		{
+			input:   "${CONFIG:-@default.yaml}",
+			output:  "key: value\n",
+			options: Options{FallbackFiles: true, FallbackFileDir: dir},
+			label:   "present",
+		},
+		{
+			input:   "${CONFIG:-@" + filepath.Join(dir, "default.yaml") + "}",
+			output:  "key: value\n",
+			options: Options{FallbackFiles: true},
+			label:   "present-absolute",
+		},
+		{
+			input:   "${CONFIG:-@missing.yaml}",
+			output:  "${CONFIG:-@missing.yaml}",
+			options: Options{FallbackFiles: true, FallbackFileDir: dir},
+			label:   "missing",
+			error:   fmt.Errorf("fallback file missing.yaml is missing"),
+		},
+		{
+			input:   "${CONFIG:-@@default.yaml}",
+			output:  "@default.yaml",
+			options: Options{FallbackFiles: true, FallbackFileDir: dir},
+			label:   "escaped",
+		},
+		{
+			input:   "${CONFIG:-@default.yaml}",
+			output:  "@default.yaml",
+			options: Options{},
+			label:   "disabled",
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandWithOptions(testCase.input, values, testCase.options)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+
+	nested := lookupMap(map[string]string{"FALLBACK_FILE": "@default.yaml", "FALLBACK_NOW": "@now", "FALLBACK_HOME": "$HOME"})
+	output, err := ExpandWithOptions([]interface{}{"${CONFIG:-${FALLBACK_FILE}}", "${CONFIG:-${FALLBACK_NOW}}", "${CONFIG:-${FALLBACK_HOME}}"}, nested, Options{FallbackFiles: true, FallbackFileDir: dir, DynamicFallbacks: true, ExpandFallbackEnv: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"@default.yaml", "@now", "$HOME"}, output)
+}
+
+func TestExpandIdempotent(t *testing.T) {
+	values := map[string]string{
+		"IDEM_A":           "a",
+		"IDEM_PLACEHOLDER": "${IDEM_A}",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+	input := map[string]interface{}{
+		"plain":       "${IDEM_A}",
+		"single":      "${IDEM_PLACEHOLDER}",
+		"embedded":    "prefix ${IDEM_PLACEHOLDER} suffix",
+		"escaped":     "\\${IDEM_A}",
+		"list":        []interface{}{"${IDEM_PLACEHOLDER}", "${IDEM_A}"},
+		"static":      "static",
+		"non-strings": 1,
+	}
+
+	first, err := ExpandWithOptions(input, lookup, Options{Idempotent: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"plain":       "a",
+		"single":      Literal("${IDEM_A}"),
+		"embedded":    Literal("prefix ${IDEM_A} suffix"),
+		"escaped":     Literal("${IDEM_A}"),
+		"list":        []interface{}{Literal("${IDEM_A}"), "a"},
+		"static":      "static",
+		"non-strings": 1,
+	}, first)
+	second, err := ExpandWithOptions(first, lookup, Options{Idempotent: true})
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	first, err = Expand(input, lookup)
+	assert.NoError(t, err)
+	second, err = Expand(first, lookup)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+type testStringer struct {
+	value string
+}
+
+func (s testStringer) String() string {
+	return s.value
+}
+
+func TestExpandStringers(t *testing.T) {
+	values := map[string]string{
+		"STR_A":  "a",
+		"STR_42": "42",
+	}
+	input := map[string]interface{}{
+		"placeholder": testStringer{value: "prefix ${STR_A} suffix"},
+		"typed":       testStringer{value: "${STR_42:number}"},
+		"static":      testStringer{value: "static"},
+	}
+
+	output, err := ExpandMap(input, values)
+	assert.NoError(t, err)
+	assert.Equal(t, input, output)
+
+	output, err = ExpandWithOptions(input, func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}, Options{ExpandStringers: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"placeholder": "prefix a suffix",
+		"typed":       "42",
+		"static":      testStringer{value: "static"},
+	}, output)
+}
+
+func TestExpandWithAllowAndDenyNames(t *testing.T) {
+	values := map[string]string{
+		"APP_HOST":    "localhost",
+		"APP_PORT":    "8080",
+		"APP_SECRET":  "secret",
+		"AWS_SECRET":  "secret",
+		"OTHER_VALUE": "other",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+	options := Options{
+		AllowNames: []string{"APP_*", "OTHER_VALUE"},
+		DenyNames:  []string{"*_SECRET"},
+	}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  "${OTHER_VALUE}",
+			output: "other",
+			label:  "allowed",
+		},
+		{
+			input:  "${APP_HOST}:${APP_PORT}",
+			output: "localhost:8080",
+			label:  "allowed-glob",
+		},
+		{
+			input:  "${APP_SECRET}",
+			output: "${APP_SECRET}",
+			label:  "denied",
+			error:  fmt.Errorf("variable APP_SECRET is not permitted"),
+		},
+		{
+			input:  "${AWS_SECRET:-fallback}",
+			output: "${AWS_SECRET:-fallback}",
+			label:  "denied-with-fallback",
+			error:  fmt.Errorf("variable AWS_SECRET is not permitted"),
+		},
+		{
+			input:  "${HOME}",
+			output: "${HOME}",
+			label:  "not-allowed",
+			error:  fmt.Errorf("variable HOME is not permitted"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandWithOptions(testCase.input, lookup, options)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+}
+
+func TestExpandPartialOutput(t *testing.T) {
+	values := map[string]string{
+		"PART_A":  "a",
+		"PART_42": "42",
+	}
+
+	output, err := ExpandMap(map[string]interface{}{
+		"single":          "${PART_UNKNOWN}",
+		"single-format":   "${PART_A:number}",
+		"embedded":        "${PART_A} ${PART_UNKNOWN}",
+		"embedded-format": "${PART_42:number} ${PART_A:number}",
+		"list":            []interface{}{"${PART_UNKNOWN}", "${PART_A} ${PART_UNKNOWN}", "${PART_42:number}"},
+	}, values)
+	assert.Error(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"single":          "${PART_UNKNOWN}",
+		"single-format":   "${PART_A:number}",
+		"embedded":        "a ${PART_UNKNOWN}",
+		"embedded-format": "42 ${PART_A:number}",
+		"list":            []interface{}{"${PART_UNKNOWN}", "a ${PART_UNKNOWN}", 42},
+	}, output)
+}
+
+func TestExpandTypedSlices(t *testing.T) {
+	type name string
+	pointerInput := "${SLICE_A}"
+	pointerOutput := "a"
+	pointerStatic := "static"
+	pointerFormat := "${SLICE_42:number}"
+	values := map[string]string{
+		"SLICE_A":  "a",
+		"SLICE_42": "42",
+	}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  []string{"${SLICE_A}", "prefix ${SLICE_A}", "static"},
+			output: []string{"a", "prefix a", "static"},
+			label:  "slice",
+		},
+		{
+			input:  [2]string{"${SLICE_A}", "${SLICE_42}"},
+			output: [2]string{"a", "42"},
+			label:  "array",
+		},
+		{
+			input:  []name{"${SLICE_A}"},
+			output: []name{"a"},
+			label:  "named-elements",
+		},
+		{
+			input:  map[string]interface{}{"list": []string{"${SLICE_A}"}},
+			output: map[string]interface{}{"list": []string{"a"}},
+			label:  "nested",
+		},
+		{
+			input:  map[string]interface{}{"field": name("${SLICE_A}"), "static": name("static")},
+			output: map[string]interface{}{"field": name("a"), "static": name("static")},
+			label:  "named-value",
+		},
+		{
+			input:  template.HTML("<b>${SLICE_A}</b>"),
+			output: template.HTML("<b>a</b>"),
+			label:  "named-html",
+		},
+		{
+			input:  name("${SLICE_42:number}"),
+			output: name("${SLICE_42:number}"),
+			label:  "named-format",
+			error:  fmt.Errorf("${SLICE_42:number} does not expand to a string"),
+		},
+		{
+			input:  map[string]interface{}{"set": &pointerInput, "unset": (*string)(nil), "static": &pointerStatic},
+			output: map[string]interface{}{"set": &pointerOutput, "unset": (*string)(nil), "static": &pointerStatic},
+			label:  "string-pointers",
+		},
+		{
+			input:  &pointerFormat,
+			output: &pointerFormat,
+			label:  "string-pointer-format",
+			error:  fmt.Errorf("${SLICE_42:number} does not expand to a string"),
+		},
+		{
+			input:  Literal("${SLICE_A}"),
+			output: Literal("${SLICE_A}"),
+			label:  "literal",
+		},
+		{
+			input:  []string(nil),
+			output: []string(nil),
+			label:  "nil",
+		},
+		{
+			input:  []string{"${SLICE_A}", "${SLICE_42:number}"},
+			output: []string{"a", "${SLICE_42:number}"},
+			label:  "typed-format",
+			error:  fmt.Errorf("at [1]: ${SLICE_42:number} does not expand to a string"),
+		},
+		{
+			input:  []string{"${SLICE_UNKNOWN}"},
+			output: []string{"${SLICE_UNKNOWN}"},
+			label:  "unknown",
+			error:  fmt.Errorf("at [0]: variable SLICE_UNKNOWN is missing"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandMap(testCase.input, values)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+	assert.Equal(t, "${SLICE_A}", pointerInput)
+}
+
+func TestExpandEnvAssign(t *testing.T) {
+	t.Setenv("ENV_ASSIGN_A", "a")
+	os.Unsetenv("ENV_ASSIGN_B")
+	t.Cleanup(func() { os.Unsetenv("ENV_ASSIGN_B") })
+
+	output, err := ExpandEnvAssign([]interface{}{
+		"${ENV_ASSIGN_A:-fallback}",
+		"${ENV_ASSIGN_B:-b}",
+		"${ENV_ASSIGN_B:-other}",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", "b", "b"}, output)
+	assert.Equal(t, "a", os.Getenv("ENV_ASSIGN_A"))
+	value, ok := os.LookupEnv("ENV_ASSIGN_B")
+	assert.True(t, ok)
+	assert.Equal(t, "b", value)
+
+	_, err = ExpandEnvAssign("${ENV_ASSIGN_UNKNOWN}")
+	assert.EqualError(t, err, "environment variable ENV_ASSIGN_UNKNOWN is missing")
+
+	t.Setenv("ENV_ASSIGN_PORT", "8080")
+	for _, name := range []string{"ENV_ASSIGN_LIST", "ENV_ASSIGN_MAP", "ENV_ASSIGN_NUMBER", "ENV_ASSIGN_SECRET"} {
+		name := name
+		os.Unsetenv(name)
+		t.Cleanup(func() { os.Unsetenv(name) })
+	}
+	output, err = ExpandEnvAssign([]interface{}{
+		"${ENV_ASSIGN_LIST:-[]}",
+		"${ENV_ASSIGN_MAP:-{}}",
+		"${ENV_ASSIGN_NUMBER:-${ENV_ASSIGN_PORT:number}}",
+		"${ENV_ASSIGN_SECRET:secret:-token}",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{[]interface{}{}, map[string]interface{}{}, 8080, "token"}, output)
+	for _, name := range []string{"ENV_ASSIGN_LIST", "ENV_ASSIGN_MAP", "ENV_ASSIGN_NUMBER", "ENV_ASSIGN_SECRET"} {
+		_, ok := os.LookupEnv(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestExpandEnvEmpty(t *testing.T) {
+	t.Setenv("ENV_EMPTY", "")
+	input := []interface{}{"${ENV_EMPTY}", "${ENV_EMPTY:-fallback}", "${ENV_EMPTY:env:-fallback}"}
+
+	output, err := ExpandEnvWithOptions(input, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"", "", ""}, output)
+
+	output, err = ExpandEnvWithOptions(input, Options{EmptyEnv: EmptyEnvFallback})
+	assert.EqualError(t, err, "at [0]: environment variable ENV_EMPTY is empty")
+	assert.Equal(t, []interface{}{"${ENV_EMPTY}", "fallback", "fallback"}, output)
+
+	output, err = ExpandEnvWithOptions(input, Options{EmptyEnv: EmptyEnvError})
+	assert.EqualError(t, err, "at [0]: environment variable ENV_EMPTY is empty, at [1]: environment variable ENV_EMPTY is empty, at [2]: environment variable ENV_EMPTY is empty")
+	assert.Equal(t, input, output)
+
+	output, err = ExpandWithOptions("${ENV_EMPTY:env:-fallback}", lookupMap(nil), Options{EmptyEnv: EmptyEnvFallback})
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback", output)
+}
+
+func TestExpandEmptyNames(t *testing.T) {
+	input := []interface{}{"${}", "${:-x}", "a ${} b", "\\${}", "${ | default:x}"}
+
+	output, err := ExpandWithOptions(input, lookupMap(nil), Options{PipeSyntax: true})
+	assert.EqualError(t, err, "at [0]: empty variable name, at [1]: empty variable name, at [2]: empty variable name, at [4]: empty variable name")
+	assert.Equal(t, []interface{}{"${}", "${:-x}", "a ${} b", "${}", "${ | default:x}"}, output)
+
+	output, err = ExpandWithOptions(input, lookupMap(nil), Options{PipeSyntax: true, KeepEmptyNames: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"${}", "${:-x}", "a ${} b", "${}", "${ | default:x}"}, output)
+}
+
+func TestExpandOnlyFormatted(t *testing.T) {
+	values := map[string]string{
+		"ONLY_PORT": "8080",
+		"ONLY_HOST": "localhost",
+	}
+
+	output, err := ExpandWithOptions([]interface{}{
+		"${ONLY_PORT:number}",
+		"${ONLY_HOST}",
+		"${ONLY_HOST:-fallback}",
+		"${ONLY_HOST:secret}",
+		"${ONLY_HOST}:${ONLY_PORT:number}",
+		"${ONLY_UNKNOWN}",
+		"${ONLY_UNKNOWN:upper:-x}",
+		"${ONLY_HOST | upper}",
+		"${ONLY_HOST | default:x}",
+	}, lookupMap(values), Options{OnlyFormatted: true, PipeSyntax: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		8080,
+		"${ONLY_HOST}",
+		"${ONLY_HOST:-fallback}",
+		"${ONLY_HOST:secret}",
+		"${ONLY_HOST}:8080",
+		"${ONLY_UNKNOWN}",
+		"X",
+		"LOCALHOST",
+		"${ONLY_HOST | default:x}",
+	}, output)
+
+	_, err = ExpandWithOptions("${ONLY_UNKNOWN:number}", lookupMap(values), Options{OnlyFormatted: true})
+	assert.EqualError(t, err, "variable ONLY_UNKNOWN is missing")
+}
+
+func TestExpandNumericString(t *testing.T) {
+	testCases := []struct {
+		value string
+		valid bool
+	}{
+		{value: "007", valid: true},
+		{value: "-1.50", valid: true},
+		{value: "+.5", valid: true},
+		{value: "1.", valid: true},
+		{value: "", valid: false},
+		{value: ".", valid: false},
+		{value: "1.2.3", valid: false},
+		{value: "NaN", valid: false},
+		{value: "Inf", valid: false},
+		{value: "-Infinity", valid: false},
+		{value: "1e5", valid: false},
+		{value: "0x1p3", valid: false},
+		{value: "1_000", valid: false},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandMap("${NUMERIC:numeric-string}", map[string]string{"NUMERIC": testCase.value})
+		if testCase.valid {
+			assert.NoError(t, err, testCase.value)
+			assert.Equal(t, testCase.value, output, testCase.value)
+		} else {
+			assert.EqualError(t, err, testCase.value+" is not a valid number", testCase.value)
+		}
+	}
+}
+
+func TestExpandFormatErrors(t *testing.T) {
+	values := map[string]string{
+		"FORMAT_PORT": "http",
+		"FORMAT_FLAG": "maybe",
+	}
+	options := Options{
+		PipeSyntax: true,
+		FormatErrors: map[string]string{
+			"number": "{name} must be a port number like 8080, got '{value}'",
+		},
+	}
+
+	_, err := ExpandWithOptions("${FORMAT_PORT:number}", lookupMap(values), options)
+	assert.EqualError(t, err, "FORMAT_PORT must be a port number like 8080, got 'http'")
+	var formatErr *formatError
+	assert.True(t, errors.As(err, &formatErr))
+	assert.EqualError(t, errors.Unwrap(formatErr), "http is not a valid number")
+
+	_, err = ExpandWithOptions("${FORMAT_PORT | number}", lookupMap(values), options)
+	assert.EqualError(t, err, "FORMAT_PORT must be a port number like 8080, got 'http'")
+
+	_, err = ExpandWithOptions("${FORMAT_FLAG:boolean}", lookupMap(values), options)
+	assert.EqualError(t, err, "maybe is not a valid boolean")
+
+	options.Redact = true
+	_, err = ExpandWithOptions("${FORMAT_PORT:number}", lookupMap(values), options)
+	assert.EqualError(t, err, "variable FORMAT_PORT: FORMAT_PORT must be a port number like 8080, got '***'")
+}
+
+func TestExpandWithRedact(t *testing.T) {
+	values := map[string]string{
+		"REDACT_SECRET": "secret123",
+		"REDACT_42":     "42",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+
+	testCases := []struct {
+		input   interface{}
+		options Options
+		label   string
+		error   error
+	}{
+		{
+			input: "${REDACT_SECRET:number}",
+			label: "disabled",
+			error: fmt.Errorf("secret123 is not a valid number"),
+		},
+		{
+			input:   "${REDACT_SECRET:number}",
+			options: Options{Redact: true},
+			label:   "number",
+			error:   fmt.Errorf("variable REDACT_SECRET: *** is not a valid number"),
+		},
+		{
+			input:   "${REDACT_SECRET:boolean}",
+			options: Options{Redact: true},
+			label:   "boolean",
+			error:   fmt.Errorf("variable REDACT_SECRET: *** is not a valid boolean"),
+		},
+		{
+			input:   "${REDACT_42:count=1..5}",
+			options: Options{Redact: true},
+			label:   "count",
+			error:   fmt.Errorf("variable REDACT_42: *** is not within 1..5"),
+		},
+		{
+			input: "${REDACT_SECRET:custom}",
+			options: Options{Redact: true, Formats: map[string]FormatFunc{
+				"custom": func(value string) (interface{}, error) {
+					return nil, fmt.Errorf("%s is not custom, got '%s'", value, value)
+				},
+			}},
+			label: "custom",
+			error: fmt.Errorf("variable REDACT_SECRET: *** is not custom, got '***'"),
+		},
+		{
+			input:   "${REDACT_UNKNOWN}",
+			options: Options{Redact: true},
+			label:   "missing",
+			error:   fmt.Errorf("variable REDACT_UNKNOWN is missing"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, err := ExpandWithOptions(testCase.input, lookup, testCase.options)
+		assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+	}
+}
+
+func TestExpandErrorPaths(t *testing.T) {
+	values := map[string]string{}
+
+	testCases := []struct {
+		input interface{}
+		label string
+		error error
+	}{
+		{
+			input: "${PATH_X}",
+			label: "root",
+			error: fmt.Errorf("variable PATH_X is missing"),
+		},
+		{
+			input: []interface{}{[]interface{}{"a", "${PATH_X}"}},
+			label: "nested-arrays",
+			error: fmt.Errorf("at [0][1]: variable PATH_X is missing"),
+		},
+		{
+			input: map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": []interface{}{"${PATH_X}"}}}},
+			label: "mixed",
+			error: fmt.Errorf("at a[0].b[0]: variable PATH_X is missing"),
+		},
+		{
+			input: []interface{}{map[string]interface{}{"a": []string{"ok", "${PATH_X}"}}},
+			label: "typed-slice",
+			error: fmt.Errorf("at [0].a[1]: variable PATH_X is missing"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, err := ExpandMap(testCase.input, values)
+		assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+	}
+}
+
+func TestExpandMapKeepMissing(t *testing.T) {
+	values := map[string]string{
+		"KEEP_A": "a",
+	}
+
+	output, missing := ExpandMapKeepMissing(map[string]interface{}{
+		"a":        "${KEEP_A}",
+		"b":        "${KEEP_B} ${KEEP_C}",
+		"list":     []interface{}{"${KEEP_C}", "${KEEP_B}"},
+		"fallback": "${KEEP_D:-d}",
+	}, values)
+	assert.Equal(t, map[string]interface{}{
+		"a":        "a",
+		"b":        "${KEEP_B} ${KEEP_C}",
+		"list":     []interface{}{"${KEEP_C}", "${KEEP_B}"},
+		"fallback": "d",
+	}, output)
+	assert.Equal(t, []string{"KEEP_B", "KEEP_C"}, missing)
+
+	output, missing = ExpandMapKeepMissing("${KEEP_A}", values)
+	assert.Equal(t, "a", output)
+	assert.Equal(t, []string{}, missing)
+
+	values["KEEP_REF"] = "KEEP_TARGET"
+	output, missing = ExpandMapKeepMissing([]interface{}{"${KEEP_X:coalesce=KEEP_A}", "${!KEEP_REF}"}, values)
+	assert.Equal(t, []interface{}{"a", "${!KEEP_REF}"}, output)
+	assert.Equal(t, []string{"KEEP_TARGET"}, missing)
+}
+
+func TestRewriteUnresolved(t *testing.T) {
+	values := map[string]string{
+		"REWRITE_A":  "a",
+		"REWRITE_42": "42",
+	}
+	rewrite := func(name string) string {
+		return "{{" + name + "}}"
+	}
+
+	output, err := RewriteUnresolved(map[string]interface{}{
+		"resolved":   "${REWRITE_A}",
+		"unresolved": "${REWRITE_X}",
+		"embedded":   "${REWRITE_A}-${REWRITE_X}-${REWRITE_Y:number}",
+		"fallback":   "${REWRITE_X:-default}",
+		"escaped":    "\\${REWRITE_X}",
+		"format":     "${REWRITE_42:number}",
+	}, lookupMap(values), rewrite)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"resolved":   "a",
+		"unresolved": "{{REWRITE_X}}",
+		"embedded":   "a-{{REWRITE_X}}-{{REWRITE_Y}}",
+		"fallback":   "default",
+		"escaped":    "${REWRITE_X}",
+		"format":     42,
+	}, output)
+
+	_, err = RewriteUnresolved("${REWRITE_A:number}", lookupMap(values), rewrite)
+	assert.EqualError(t, err, "a is not a valid number")
+
+	failing := func(key string) (*string, error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	output, err = RewriteUnresolved("${REWRITE_X}", failing, rewrite)
+	assert.EqualError(t, err, "connection refused")
+	assert.Equal(t, "${REWRITE_X}", output)
+}
+
+func TestExpandErrorOrder(t *testing.T) {
+	input := map[string]interface{}{
+		"d": "${ORDER_D}",
+		"a": "${ORDER_A1} ${ORDER_A2}",
+		"c": map[string]interface{}{"z": "${ORDER_CZ}", "b": "${ORDER_CB}"},
+		"b": []interface{}{"${ORDER_B0}", "", "${ORDER_B2}", "", "", "", "", "", "", "", "${ORDER_B10}"},
+	}
+
+	for i := 0; i < 20; i++ {
+		_, err := ExpandMap(input, map[string]string{})
+		assert.EqualError(t, err, "at a: variable ORDER_A1 is missing, "+
+			"at a: variable ORDER_A2 is missing, "+
+			"at b[0]: variable ORDER_B0 is missing, "+
+			"at b[2]: variable ORDER_B2 is missing, "+
+			"at b[10]: variable ORDER_B10 is missing, "+
+			"at c.b: variable ORDER_CB is missing, "+
+			"at c.z: variable ORDER_CZ is missing, "+
+			"at d: variable ORDER_D is missing")
+	}
+}
+
+func TestExpandWithOutputEscaping(t *testing.T) {
+	values := map[string]string{
+		"ESC_SPACES": "hello world",
+		"ESC_SAFE":   "/usr/bin",
+		"ESC_QUOTE":  `say "hi"`,
+		"ESC_42":     "42",
+	}
+	input := []interface{}{
+		"NAME=${ESC_SPACES}",
+		"PATH=${ESC_SAFE}",
+		"${ESC_QUOTE}",
+		"${ESC_42:number}",
+		"${ESC_UNKNOWN:-${ESC_SPACES}}",
+	}
+
+	testCases := []struct {
+		escaping OutputEscaping
+		output   interface{}
+		error    error
+	}{
+		{
+			escaping: OutputEscapingNone,
+			output:   []interface{}{"NAME=hello world", "PATH=/usr/bin", `say "hi"`, 42, "hello world"},
+		},
+		{
+			escaping: OutputEscapingShell,
+			output:   []interface{}{"NAME='hello world'", "PATH=/usr/bin", `'say "hi"'`, 42, "'hello world'"},
+		},
+		{
+			escaping: OutputEscapingJSON,
+			output:   []interface{}{"NAME=hello world", "PATH=/usr/bin", `say \"hi\"`, 42, "hello world"},
+		},
+		{
+			escaping: OutputEscaping("xml"),
+			output:   []interface{}{"NAME=${ESC_SPACES}", "PATH=${ESC_SAFE}", "${ESC_QUOTE}", 42, "${ESC_UNKNOWN:-${ESC_SPACES}}"},
+			error:    fmt.Errorf("at [0]: output escaping xml is not supported, at [1]: output escaping xml is not supported, at [2]: output escaping xml is not supported, at [4]: output escaping xml is not supported"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandWithOptions(input, lookupMap(values), Options{OutputEscaping: testCase.escaping})
+		if testCase.error == nil {
+			assert.NoError(t, err, string(testCase.escaping))
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), string(testCase.escaping))
+		}
+		assert.Equal(t, testCase.output, output, string(testCase.escaping))
+	}
+}
+
+func TestExpandAllOrNothing(t *testing.T) {
+	values := map[string]string{
+		"ALL_A": "a",
+	}
+	input := map[string]interface{}{
+		"a":       "${ALL_A}",
+		"unknown": "${ALL_UNKNOWN}",
+	}
+
+	output, err := ExpandWithOptions(input, lookupMap(values), Options{AllOrNothing: true})
+	assert.EqualError(t, err, "at unknown: variable ALL_UNKNOWN is missing")
+	assert.Nil(t, output)
+
+	output, err = ExpandWithOptions(input, lookupMap(values), Options{AllOrNothing: true, FailFast: true})
+	assert.Error(t, err)
+	assert.Nil(t, output)
+
+	output, err = ExpandWithOptions(map[string]interface{}{"a": "${ALL_A}"}, lookupMap(values), Options{AllOrNothing: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "a"}, output)
+}
+
+func TestExpandIndirection(t *testing.T) {
+	values := map[string]string{
+		"IND_REF":       "IND_TARGET",
+		"IND_TARGET":    "value",
+		"IND_DANGLING":  "IND_UNKNOWN",
+		"IND_FORBIDDEN": "IND_SECRET",
+		"IND_SECRET":    "secret",
+		"IND_PORT_REF":  "IND_PORT",
+		"IND_PORT":      "8080",
+	}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		error  error
+	}{
+		{input: "${!IND_REF}", output: "value"},
+		{input: "x-${!IND_REF}-${IND_REF}", output: "x-value-IND_TARGET"},
+		{input: "${!IND_PORT_REF:number}", output: 8080},
+		{input: "${!IND_DANGLING:-fallback}", output: "fallback"},
+		{input: "${!IND_UNKNOWN:-fallback}", output: "fallback"},
+		{input: "${!IND_DANGLING}", output: "${!IND_DANGLING}", error: fmt.Errorf("indirection via IND_DANGLING: variable IND_UNKNOWN is missing")},
+		{input: "${!IND_UNKNOWN}", output: "${!IND_UNKNOWN}", error: fmt.Errorf("variable IND_UNKNOWN is missing")},
+		{input: "${!IND_FORBIDDEN}", output: "${!IND_FORBIDDEN}", error: fmt.Errorf("indirection via IND_FORBIDDEN: variable IND_SECRET is not permitted")},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := ExpandWithOptions(testCase.input, lookupMap(values), Options{DenyNames: []string{"*_SECRET"}})
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+}
+
+func TestExpandReusesUnchangedSubtrees(t *testing.T) {
+	static := map[string]interface{}{"list": []interface{}{"a", 1, true}, "b": "b"}
+	input := map[string]interface{}{
+		"static":  static,
+		"dynamic": []interface{}{"${REUSE_A}", static},
+	}
+
+	output, err := ExpandMap(input, map[string]string{"REUSE_A": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"static":  static,
+		"dynamic": []interface{}{"a", static},
+	}, output)
+	assert.Equal(t, reflect.ValueOf(static).Pointer(), reflect.ValueOf(output.(map[string]interface{})["static"]).Pointer())
+	assert.Equal(t, reflect.ValueOf(static).Pointer(), reflect.ValueOf(output.(map[string]interface{})["dynamic"].([]interface{})[1]).Pointer())
+	assert.Equal(t, "${REUSE_A}", input["dynamic"].([]interface{})[0])
+
+	output, err = ExpandMap(static, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, reflect.ValueOf(static).Pointer(), reflect.ValueOf(output).Pointer())
+}
+
+func BenchmarkExpandDeepStaticDocument(b *testing.B) {
+	var build func(depth int) interface{}
+	build = func(depth int) interface{} {
+		if depth == 0 {
+			return []interface{}{"static", 42, true}
+		}
+		m := map[string]interface{}{}
+		for i := 0; i < 4; i++ {
+			m[fmt.Sprintf("key%d", i)] = build(depth - 1)
+		}
+		return m
+	}
+	input := build(6)
+	values := lookupMap(map[string]string{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Expand(input, values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExpandWithChanged(t *testing.T) {
+	values := map[string]string{
+		"CHANGED_A":    "a",
+		"CHANGED_SELF": "${CHANGED_SELF}",
+	}
+
+	testCases := []struct {
+		input   interface{}
+		changed bool
+	}{
+		{input: map[string]interface{}{"a": []interface{}{"static", 1}, "b": "b"}, changed: false},
+		{input: []string{"static"}, changed: false},
+		{input: "${CHANGED_UNKNOWN:-}", changed: true},
+		{input: map[string]interface{}{"a": []interface{}{"static", "${CHANGED_A}"}}, changed: true},
+		{input: "\\${CHANGED_A}", changed: true},
+		{input: "${CHANGED_UNKNOWN}", changed: false},
+		{input: "${CHANGED_SELF}", changed: true},
+		{input: []string{"${CHANGED_SELF}"}, changed: true},
+		{input: map[string]interface{}{"a": "${CHANGED_SELF}"}, changed: true},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		_, changed, _ := ExpandWithChanged(testCase.input, lookupMap(values), Options{})
+		assert.Equal(t, testCase.changed, changed, label)
+	}
+}
+
+func TestExpandWithOverrides(t *testing.T) {
+	t.Setenv("OVERRIDE_HOST", "env.local")
+	t.Setenv("OVERRIDE_PORT", "8080")
+
+	output, err := ExpandWithOverrides("${OVERRIDE_HOST}:${OVERRIDE_PORT}/${OVERRIDE_DB}", lookupEnv, map[string]string{
+		"OVERRIDE_HOST": "test.local",
+		"OVERRIDE_DB":   "test",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "test.local:8080/test", output)
+
+	_, err = ExpandWithOverrides("${OVERRIDE_UNKNOWN}", lookupEnv, nil)
+	assert.EqualError(t, err, "environment variable OVERRIDE_UNKNOWN is missing")
+}
+
+func TestExpandKeysValues(t *testing.T) {
+	keys := map[string]string{
+		"KV_SERVICE": "billing",
+		"KV_SECRET":  "from-keys",
+	}
+	secrets := map[string]string{
+		"KV_SECRET":  "s3cr3t",
+		"KV_SERVICE": "from-values",
+	}
+
+	output, err := ExpandKeysValues(map[string]interface{}{
+		"${KV_SERVICE}": map[string]interface{}{
+			"password":          "${KV_SECRET}",
+			"${KV_SERVICE}-url": "https://${KV_SERVICE}",
+		},
+	}, lookupMap(keys), lookupMap(secrets))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"billing": map[string]interface{}{
+			"password":    "s3cr3t",
+			"billing-url": "https://from-values",
+		},
+	}, output)
+
+	_, err = ExpandKeysValues(map[string]interface{}{"${KV_SECRET_ONLY}": "${KV_SECRET}"}, lookupMap(keys), lookupMap(secrets))
+	assert.EqualError(t, err, "at ${KV_SECRET_ONLY}: variable KV_SECRET_ONLY is missing")
+}
+
+func TestExpandMaxDepth(t *testing.T) {
+	nest := func(depth int) interface{} {
+		var current interface{} = "${DEPTH_A}"
+		for i := 0; i < depth; i++ {
+			current = []interface{}{current}
+		}
+		return current
+	}
+	values := lookupMap(map[string]string{"DEPTH_A": "a"})
+
+	output, err := ExpandWithOptions(nest(2), values, Options{MaxDepth: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", output.([]interface{})[0].([]interface{})[0])
+
+	_, err = ExpandWithOptions(nest(3), values, Options{MaxDepth: 3})
+	assert.EqualError(t, err, "at [0][0][0]: document nesting too deep")
+
+	_, err = Expand(nest(999), values)
+	assert.NoError(t, err)
+
+	_, err = Expand(nest(100000), values)
+	assert.ErrorContains(t, err, "document nesting too deep")
+}
+
+func TestExpandMapWithEnvMarker(t *testing.T) {
+	t.Setenv("MARKER_HOST", "env.local")
+	t.Setenv("MARKER_PORT", "8080")
+	t.Setenv("MARKER_SECRET", "secret")
+	values := map[string]string{
+		"MARKER_HOST": "map.local",
+		"MARKER_USER": "admin",
+	}
+
+	output, err := ExpandMap(map[string]interface{}{
+		"host":     "${MARKER_HOST}",
+		"env-host": "${MARKER_HOST:env}",
+		"port":     "${MARKER_PORT:env:number}",
+		"user":     "${MARKER_USER:env:-${MARKER_USER}}",
+	}, values)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host":     "map.local",
+		"env-host": "env.local",
+		"port":     8080,
+		"user":     "admin",
+	}, output)
+
+	_, err = ExpandMap("${MARKER_USER:env}", values)
+	assert.EqualError(t, err, "environment variable MARKER_USER is missing")
+
+	_, err = ExpandWithOptions("${MARKER_SECRET:env}", lookupMap(values), Options{DenyNames: []string{"*_SECRET"}})
+	assert.EqualError(t, err, "variable MARKER_SECRET is not permitted")
+}
+
+func TestExpandMultiValueMaps(t *testing.T) {
+	values := map[string]string{
+		"MULTI_TOKEN": "abc",
+		"MULTI_PAGE":  "2",
+	}
+
+	header := http.Header{
+		"Authorization": {"Bearer ${MULTI_TOKEN}"},
+		"Accept":        {"application/json", "text/plain"},
+	}
+	output, err := ExpandMap(header, values)
+	assert.NoError(t, err)
+	assert.Equal(t, http.Header{
+		"Authorization": {"Bearer abc"},
+		"Accept":        {"application/json", "text/plain"},
+	}, output)
+	assert.Equal(t, "Bearer ${MULTI_TOKEN}", header.Get("Authorization"))
+
+	output, err = ExpandMap(url.Values{"page": {"${MULTI_PAGE}"}}, values)
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{"page": {"2"}}, output)
+
+	output, err = ExpandMap(map[string]interface{}{
+		"headers": map[string][]string{"X-Page": {"${MULTI_PAGE:number}", "${MULTI_UNKNOWN}"}},
+	}, values)
+	assert.EqualError(t, err, "at headers.X-Page[0]: ${MULTI_PAGE:number} does not expand to a string, at headers.X-Page[1]: variable MULTI_UNKNOWN is missing")
+	assert.Equal(t, map[string]interface{}{
+		"headers": map[string][]string{"X-Page": {"${MULTI_PAGE:number}", "${MULTI_UNKNOWN}"}},
+	}, output)
+}
+
+func TestExpandKeysNestedCollision(t *testing.T) {
+	values := map[string]string{
+		"COLLIDE_A":    "x",
+		"COLLIDE_B":    "x",
+		"COLLIDE_NAME": "app",
+	}
+
+	output, err := ExpandWithOptions(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"labels": map[string]interface{}{
+				"${COLLIDE_A}":    "1",
+				"${COLLIDE_B}":    "2",
+				"${COLLIDE_NAME}": "${COLLIDE_NAME}",
+			},
+			"containers": []interface{}{
+				map[string]interface{}{"${COLLIDE_A}": 1, "x": 2},
+			},
+		},
+	}, lookupMap(values), Options{ExpandKeys: true})
+	assert.EqualError(t, err, "at spec.containers[0]: keys ${COLLIDE_A} and x both expand to 'x', at spec.labels: keys ${COLLIDE_A} and ${COLLIDE_B} both expand to 'x'")
+	assert.Equal(t, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"labels": map[string]interface{}{
+				"x":   "1",
+				"app": "app",
+			},
+			"containers": []interface{}{
+				map[string]interface{}{"x": 1},
+			},
+		},
+	}, output)
+}
+
+func TestExpandMapMerge(t *testing.T) {
+	defaults := map[string]string{
+		"MERGE_HOST": "localhost",
+		"MERGE_PORT": "5432",
+		"MERGE_USER": "app",
+	}
+	overrides := map[string]string{
+		"MERGE_HOST": "db.local",
+	}
+	secrets := map[string]string{
+		"MERGE_HOST":     "secret.local",
+		"MERGE_PASSWORD": "s3cr3t",
+	}
+
+	output, err := ExpandMapMerge("${MERGE_USER}:${MERGE_PASSWORD}@${MERGE_HOST}:${MERGE_PORT}", defaults, overrides, secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, "app:s3cr3t@secret.local:5432", output)
+
+	output, err = ExpandMapMerge("${MERGE_HOST}", secrets, overrides)
+	assert.NoError(t, err)
+	assert.Equal(t, "db.local", output)
+
+	_, err = ExpandMapMerge("${MERGE_HOST}")
+	assert.EqualError(t, err, "variable MERGE_HOST is missing")
+}
+
+func TestExpandEnvTransitive(t *testing.T) {
+	t.Setenv("TRANS_A", "${TRANS_B}")
+	t.Setenv("TRANS_B", "c")
+	t.Setenv("TRANS_URL", "https://${TRANS_HOST:-localhost}:${TRANS_PORT}")
+	t.Setenv("TRANS_PORT", "8080")
+	t.Setenv("TRANS_SELF", "${TRANS_SELF}")
+	t.Setenv("TRANS_X", "x${TRANS_Y}")
+	t.Setenv("TRANS_Y", "y${TRANS_X}")
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		error  error
+	}{
+		{input: "${TRANS_A}", output: "c"},
+		{input: "${TRANS_URL}", output: "https://localhost:8080"},
+		{input: "${TRANS_SELF}", output: "${TRANS_SELF}", error: fmt.Errorf("variable cycle detected: TRANS_SELF -> TRANS_SELF")},
+		{input: "${TRANS_X}", output: "${TRANS_X}", error: fmt.Errorf("variable cycle detected: TRANS_X -> TRANS_Y -> TRANS_X")},
+		{input: "${TRANS_SELF:-fallback}", output: "fallback"},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := ExpandEnvWithOptions(testCase.input, Options{Transitive: true})
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+
+	output, err := ExpandEnv("${TRANS_A}")
+	assert.NoError(t, err)
+	assert.Equal(t, "${TRANS_B}", output)
+}
@@ -0,0 +1,25 @@
+package expandenv
+
+// Expander bundles options with a registry of custom formats, so formats can
+// be registered without any global state.
+type Expander struct {
+	Options Options
+}
+
+func NewExpander(options Options) *Expander {
+	return &Expander{Options: options}
+}
+
+// RegisterFormat makes ${X:name} dispatch to fn.
+func (e *Expander) RegisterFormat(name string, fn FormatFunc) {
+	formats := map[string]FormatFunc{}
+	for k, v := range e.Options.Formats {
+		formats[k] = v
+	}
+	formats[name] = fn
+	e.Options.Formats = formats
+}
+
+func (e *Expander) Expand(input interface{}, values VariableLookup) (interface{}, error) {
+	return ExpandWithOptions(input, values, e.Options)
+}
@@ -0,0 +1,76 @@
+package expandenv
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSemver struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func TestExpanderRegisterFormat(t *testing.T) {
+	values := map[string]string{
+		"EXP_VERSION": "1.2.3",
+		"EXP_INVALID": "1.2",
+	}
+	expander := NewExpander(Options{})
+	expander.RegisterFormat("semver", func(value string) (interface{}, error) {
+		p := regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`).FindStringSubmatch(value)
+		if p == nil {
+			return nil, fmt.Errorf("%s is not a valid semver", value)
+		}
+		major, _ := strconv.Atoi(p[1])
+		minor, _ := strconv.Atoi(p[2])
+		patch, _ := strconv.Atoi(p[3])
+		return testSemver{Major: major, Minor: minor, Patch: patch}, nil
+	})
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  "${EXP_VERSION:semver}",
+			output: testSemver{Major: 1, Minor: 2, Patch: 3},
+			label:  "custom",
+		},
+		{
+			input:  "${EXP_INVALID:semver}",
+			output: "${EXP_INVALID:semver}",
+			label:  "custom-invalid",
+			error:  fmt.Errorf("1.2 is not a valid semver"),
+		},
+		{
+			input:  "${EXP_VERSION:semver=strict}",
+			output: "${EXP_VERSION:semver=strict}",
+			label:  "custom-with-options",
+			error:  fmt.Errorf("format semver does not take options"),
+		},
+		{
+			input:  "${EXP_VERSION:string}",
+			output: "1.2.3",
+			label:  "built-in",
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := expander.Expand(testCase.input, lookupMap(values))
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+
+	assert.Nil(t, NewExpander(Options{}).Options.Formats["semver"])
+}
@@ -0,0 +1,113 @@
+package expandenv
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// emptyFallbacks are the fallbacks producing typed empty values instead of
+// strings, if no format is given.
+var emptyFallbacks = map[string]func() interface{}{
+	"[]": func() interface{} { return []interface{}{} },
+	"{}": func() interface{} { return map[string]interface{}{} },
+}
+
+// errorFallback tells whether a fallback like !err please set VAR raises an
+// error instead, and returns its message.
+func errorFallback(fallback string) (string, bool) {
+	if fallback != "!err" && !strings.HasPrefix(fallback, "!err ") {
+		return "", false
+	}
+	return strings.TrimSpace(fallback[len("!err"):]), true
+}
+
+func resolveFallback(fallback string, options Options) (string, error) {
+	if len(options.AllowedCommands) > 0 && strings.HasPrefix(fallback, "$(") && strings.HasSuffix(fallback, ")") {
+		return runCommandFallback(fallback[2:len(fallback)-1], options)
+	}
+	if options.ExpandFallbackEnv {
+		fallback = expandShellEnv(fallback)
+	}
+	if !options.DynamicFallbacks && !options.FallbackFiles {
+		return fallback, nil
+	}
+	if strings.HasPrefix(fallback, "@@") {
+		return fallback[1:], nil
+	}
+	if options.DynamicFallbacks {
+		switch fallback {
+		case "@now":
+			return time.Now().Format(time.RFC3339), nil
+		case "@uuid":
+			return newUUID()
+		}
+	}
+	if options.FallbackFiles {
+		if options.FallbackFS != nil {
+			return readFallbackFileFS(fallback, options.FallbackFS, options.FallbackFileDir)
+		}
+		return readFallbackFile(fallback, options.FallbackFileDir)
+	}
+	return fallback, nil
+}
+
+// runCommandFallback runs the command of a fallback like $(git rev-parse
+// HEAD) if the whole command line, compared field by field, is allowed.
+func runCommandFallback(command string, options Options) (string, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("command fallback $(%s) is empty", command)
+	}
+	line := strings.Join(fields, " ")
+	allowed := false
+	for _, allowedLine := range options.AllowedCommands {
+		allowed = allowed || strings.Join(strings.Fields(allowedLine), " ") == line
+	}
+	if !allowed {
+		return "", fmt.Errorf("command %s is not allowed", line)
+	}
+	runner := options.CommandRunner
+	if runner == nil {
+		runner = runCommand
+	}
+	output, err := runner(fields[0], fields[1:]...)
+	if err != nil {
+		return "", fmt.Errorf("command %s failed: %w", fields[0], err)
+	}
+	return strings.TrimRight(output, "\r\n"), nil
+}
+
+func runCommand(name string, args ...string) (string, error) {
+	output, err := exec.Command(name, args...).Output()
+	return string(output), err
+}
+
+func readFallbackFileFS(fallback string, fsys fs.FS, dir string) (string, error) {
+	if !strings.HasPrefix(fallback, "@") {
+		return fallback, nil
+	}
+	content, err := fs.ReadFile(fsys, path.Join(dir, fallback[1:]))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("fallback file %s is missing", fallback[1:])
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
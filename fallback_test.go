@@ -0,0 +1,139 @@
+package expandenv
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandWithDynamicFallbacks(t *testing.T) {
+	values := func(key string) (*string, error) {
+		return nil, fmt.Errorf("variable %s is missing", key)
+	}
+	options := Options{DynamicFallbacks: true}
+
+	output, err := ExpandWithOptions("${BUILD_TIME:-@now}", values, options)
+	assert.NoError(t, err)
+	_, err = time.Parse(time.RFC3339, output.(string))
+	assert.NoError(t, err)
+
+	output, err = ExpandWithOptions("${BUILD_ID:-@uuid}", values, options)
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), output)
+
+	output, err = ExpandWithOptions("${BUILD_TIME:-@@now}", values, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "@now", output)
+
+	output, err = ExpandWithOptions("${BUILD_TIME:-@other}", values, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "@other", output)
+
+	output, err = ExpandWithOptions("${BUILD_TIME:-@now}", values, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "@now", output)
+}
+
+func TestExpandWithCommandFallbacks(t *testing.T) {
+	values := lookupMap(map[string]string{"CMD_REV": "abc123"})
+	calls := [][]string{}
+	options := Options{
+		AllowedCommands: []string{"git rev-parse HEAD", "git  fail"},
+		CommandRunner: func(name string, args ...string) (string, error) {
+			calls = append(calls, append([]string{name}, args...))
+			if len(args) > 0 && args[0] == "fail" {
+				return "", fmt.Errorf("exit status 1")
+			}
+			return "def456\n", nil
+		},
+	}
+
+	output, err := ExpandWithOptions("${CMD_UNKNOWN:-$(git rev-parse HEAD)}", values, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "def456", output)
+	assert.Equal(t, [][]string{{"git", "rev-parse", "HEAD"}}, calls)
+
+	output, err = ExpandWithOptions("${CMD_REV:-$(git rev-parse HEAD)}", values, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", output)
+	assert.Len(t, calls, 1)
+
+	_, err = ExpandWithOptions("${CMD_UNKNOWN:-$(rm -rf /)}", values, options)
+	assert.EqualError(t, err, "command rm -rf / is not allowed")
+
+	_, err = ExpandWithOptions("${CMD_UNKNOWN:-$(git rev-parse HEAD; rm -rf /)}", values, options)
+	assert.EqualError(t, err, "command git rev-parse HEAD; rm -rf / is not allowed")
+
+	_, err = ExpandWithOptions("${CMD_UNKNOWN:-$(git push)}", values, options)
+	assert.EqualError(t, err, "command git push is not allowed")
+
+	_, err = ExpandWithOptions("${CMD_UNKNOWN:-$(git fail)}", values, options)
+	assert.EqualError(t, err, "command git failed: exit status 1")
+
+	output, err = ExpandWithOptions("${CMD_UNKNOWN:-$(git rev-parse HEAD)}", values, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "$(git rev-parse HEAD)", output)
+	assert.Len(t, calls, 2)
+
+	t.Setenv("CMD_LINE", "$(git rev-parse HEAD)")
+	envOptions := options
+	envOptions.ExpandFallbackEnv = true
+	output, err = ExpandWithOptions("${CMD_UNKNOWN:-$CMD_LINE}", values, envOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, "$(git rev-parse HEAD)", output)
+
+	injected := lookupMap(map[string]string{"CMD_INJECTED": "$(git rev-parse HEAD)"})
+	output, err = ExpandWithOptions("${CMD_UNKNOWN:-${CMD_INJECTED}}", injected, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "$(git rev-parse HEAD)", output)
+	assert.Len(t, calls, 2)
+}
+
+func TestExpandWithFallbackFS(t *testing.T) {
+	values := lookupMap(map[string]string{})
+	options := Options{
+		FallbackFiles:   true,
+		FallbackFS:      fstest.MapFS{"defaults/config.yaml": {Data: []byte("key: value\n")}},
+		FallbackFileDir: "defaults",
+	}
+
+	output, err := ExpandWithOptions("${CONFIG:-@config.yaml}", values, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "key: value\n", output)
+
+	_, err = ExpandWithOptions("${CONFIG:-@unknown.yaml}", values, options)
+	assert.EqualError(t, err, "fallback file unknown.yaml is missing")
+}
+
+func TestExpandWithErrorFallbacks(t *testing.T) {
+	values := lookupMap(map[string]string{"ERR_A": "a"})
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		error  error
+	}{
+		{input: "${ERR_A:-!err please provide ERR_A in CI}", output: "a"},
+		{input: "${ERR_UNKNOWN:-!err please provide ERR_UNKNOWN in CI}", output: "${ERR_UNKNOWN:-!err please provide ERR_UNKNOWN in CI}", error: fmt.Errorf("please provide ERR_UNKNOWN in CI")},
+		{input: "${ERR_UNKNOWN:-!err}", output: "${ERR_UNKNOWN:-!err}", error: fmt.Errorf("variable ERR_UNKNOWN is missing")},
+		{input: "${ERR_UNKNOWN:-!!err literal}", output: "!err literal"},
+		{input: "${ERR_UNKNOWN:-!!err}", output: "!err"},
+		{input: "${ERR_UNKNOWN:-!!errand}", output: "!!errand"},
+		{input: "${ERR_UNKNOWN:-!errata}", output: "!errata"},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := Expand(testCase.input, values)
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+}
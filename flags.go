@@ -0,0 +1,56 @@
+package expandenv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExpandFlagMap expands both keys and values of a flat string map, as
+// produced by --set k=v style flags. Every key and value must expand to a
+// string and no two keys may expand to the same result.
+func ExpandFlagMap(m map[string]string, values VariableLookup) (map[string]string, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := map[string]string{}
+	origins := map[string]string{}
+	errMsgs := []string{}
+	for _, k := range keys {
+		key, err := expandString(k, values)
+		if err != nil {
+			errMsgs = append(errMsgs, err.Error())
+			continue
+		}
+		value, err := expandString(m[k], values)
+		if err != nil {
+			errMsgs = append(errMsgs, err.Error())
+			continue
+		}
+		if origin, ok := origins[key]; ok {
+			errMsgs = append(errMsgs, keyCollisionError(origin, k, key).Error())
+			continue
+		}
+		origins[key] = k
+		result[key] = value
+	}
+	if len(errMsgs) > 0 {
+		return result, fmt.Errorf(strings.Join(errMsgs, ", "))
+	}
+	return result, nil
+}
+
+func expandString(str string, values VariableLookup) (string, error) {
+	expanded, err := Expand(str, values)
+	if err != nil {
+		return str, err
+	}
+	result, ok := expanded.(string)
+	if !ok {
+		return str, fmt.Errorf("%s does not expand to a string", str)
+	}
+	return result, nil
+}
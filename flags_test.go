@@ -0,0 +1,77 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandFlagMap(t *testing.T) {
+	values := func(key string) (*string, error) {
+		switch key {
+		case "ENV":
+			result := "prod"
+			return &result, nil
+		case "OTHER_ENV":
+			result := "prod"
+			return &result, nil
+		case "REPLICAS":
+			result := "3"
+			return &result, nil
+		default:
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+	}
+
+	testCases := []struct {
+		input  map[string]string
+		output map[string]string
+		label  string
+		error  error
+	}{
+		{
+			input:  map[string]string{"static": "value"},
+			output: map[string]string{"static": "value"},
+			label:  "static",
+		},
+		{
+			input:  map[string]string{"${ENV}.replicas": "3"},
+			output: map[string]string{"prod.replicas": "3"},
+			label:  "key",
+		},
+		{
+			input:  map[string]string{"replicas": "${REPLICAS}"},
+			output: map[string]string{"replicas": "3"},
+			label:  "value",
+		},
+		{
+			input:  map[string]string{"${ENV}": "a", "${OTHER_ENV}": "b"},
+			output: map[string]string{"prod": "a"},
+			label:  "collision",
+			error:  fmt.Errorf("keys ${ENV} and ${OTHER_ENV} both expand to 'prod'"),
+		},
+		{
+			input:  map[string]string{"replicas": "${REPLICAS:number}"},
+			output: map[string]string{},
+			label:  "non-string",
+			error:  fmt.Errorf("${REPLICAS:number} does not expand to a string"),
+		},
+		{
+			input:  map[string]string{"replicas": "${UNKNOWN}"},
+			output: map[string]string{},
+			label:  "unknown",
+			error:  fmt.Errorf("variable UNKNOWN is missing"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandFlagMap(testCase.input, values)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+}
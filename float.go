@@ -0,0 +1,35 @@
+package expandenv
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FormattedFloat is a float that is rendered as Text when printed or
+// marshalled to YAML or JSON. It is produced by the number format when
+// Options.FloatFormat is set.
+type FormattedFloat struct {
+	Value float64
+	Text  string
+}
+
+func (f FormattedFloat) String() string {
+	return f.Text
+}
+
+func (f FormattedFloat) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: f.Text}, nil
+}
+
+// MarshalJSON rejects NaN and infinite values like encoding/json does for
+// plain floats, as JSON cannot represent them.
+func (f FormattedFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(f.Value) || math.IsInf(f.Value, 0) {
+		return nil, &json.UnsupportedValueError{Value: reflect.ValueOf(f.Value), Str: strconv.FormatFloat(f.Value, 'g', -1, 64)}
+	}
+	return []byte(f.Text), nil
+}
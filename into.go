@@ -0,0 +1,93 @@
+package expandenv
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ExpandInto expands all strings reachable from dst in place instead of
+// building a new structure. dst must be a non-nil pointer, as non-addressable
+// values cannot be modified. Typed strings (e.g. from ${X:number}) can only
+// be stored into interface{} values, everywhere else they are rejected.
+// Named string types keep their type. Pointers already visited are skipped,
+// so cyclic structures are fine.
+func ExpandInto(dst interface{}, values VariableLookup) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("destination must be a non-nil pointer")
+	}
+	errs := []error{}
+	visited := map[uintptr]bool{}
+	var recursion func(current reflect.Value)
+	recursion = func(current reflect.Value) {
+		switch current.Kind() {
+		case reflect.Pointer:
+			if current.IsNil() || visited[current.Pointer()] {
+				return
+			}
+			visited[current.Pointer()] = true
+			recursion(current.Elem())
+		case reflect.Interface:
+			if current.IsNil() {
+				return
+			}
+			elem := current.Elem()
+			if elem.Kind() == reflect.String {
+				expanded, err := Expand(elem.String(), values)
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+				if elem.Type() != reflect.TypeOf("") {
+					if reflect.ValueOf(expanded).Kind() != reflect.String {
+						errs = append(errs, fmt.Errorf("%s does not expand to a string", elem.String()))
+						return
+					}
+					current.Set(reflect.ValueOf(expanded).Convert(elem.Type()))
+					return
+				}
+				current.Set(reflect.ValueOf(expanded))
+				return
+			}
+			copied := reflect.New(elem.Type()).Elem()
+			copied.Set(elem)
+			recursion(copied)
+			current.Set(copied)
+		case reflect.Struct:
+			for i := 0; i < current.NumField(); i++ {
+				if current.Field(i).CanSet() {
+					recursion(current.Field(i))
+				}
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < current.Len(); i++ {
+				recursion(current.Index(i))
+			}
+		case reflect.Map:
+			iter := current.MapRange()
+			for iter.Next() {
+				copied := reflect.New(iter.Value().Type()).Elem()
+				copied.Set(iter.Value())
+				recursion(copied)
+				current.SetMapIndex(iter.Key(), copied)
+			}
+		case reflect.String:
+			if !current.CanSet() {
+				return
+			}
+			str := current.String()
+			expanded, err := Expand(str, values)
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			if reflect.ValueOf(expanded).Kind() != reflect.String {
+				errs = append(errs, fmt.Errorf("%s does not expand to a string", str))
+				return
+			}
+			current.SetString(reflect.ValueOf(expanded).String())
+		}
+	}
+	recursion(rv)
+	return joinErrors(errs)
+}
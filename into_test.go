@@ -0,0 +1,93 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandInto(t *testing.T) {
+	type nested struct {
+		Value string
+	}
+	type config struct {
+		Name    string
+		Port    int
+		Tags    []string
+		Nested  nested
+		Pointer *nested
+		Extra   map[string]interface{}
+		Region  *string
+		Zone    *string
+		private string
+	}
+	values := map[string]string{
+		"INTO_A":  "a",
+		"INTO_42": "42",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+
+	region := "eu-${INTO_A}"
+	expandedRegion := "eu-a"
+	cfg := config{
+		Name:    "${INTO_A}",
+		Port:    1,
+		Tags:    []string{"${INTO_A}", "static"},
+		Nested:  nested{Value: "prefix ${INTO_A}"},
+		Pointer: &nested{Value: "${INTO_A}"},
+		Extra:   map[string]interface{}{"number": "${INTO_42:number}", "list": []interface{}{"${INTO_A}"}},
+		Region:  &region,
+		private: "${INTO_A}",
+	}
+	err := ExpandInto(&cfg, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, config{
+		Name:    "a",
+		Port:    1,
+		Tags:    []string{"a", "static"},
+		Nested:  nested{Value: "prefix a"},
+		Pointer: &nested{Value: "a"},
+		Extra:   map[string]interface{}{"number": 42, "list": []interface{}{"a"}},
+		Region:  &expandedRegion,
+		private: "${INTO_A}",
+	}, cfg)
+	assert.Nil(t, cfg.Zone)
+
+	m := map[string]string{"a": "${INTO_A}", "b": "${INTO_UNKNOWN}"}
+	err = ExpandInto(&m, lookup)
+	assert.EqualError(t, err, "variable INTO_UNKNOWN is missing")
+	assert.Equal(t, map[string]string{"a": "a", "b": "${INTO_UNKNOWN}"}, m)
+
+	s := struct{ Port string }{Port: "${INTO_42:number}"}
+	err = ExpandInto(&s, lookup)
+	assert.EqualError(t, err, "${INTO_42:number} does not expand to a string")
+
+	type name string
+	named := map[string]interface{}{"name": name("${INTO_A}"), "port": name("${INTO_42:number}")}
+	err = ExpandInto(&named, lookup)
+	assert.EqualError(t, err, "${INTO_42:number} does not expand to a string")
+	assert.Equal(t, map[string]interface{}{"name": name("a"), "port": name("${INTO_42:number}")}, named)
+
+	type node struct {
+		Value string
+		Next  *node
+	}
+	cycle := &node{Value: "${INTO_A}"}
+	cycle.Next = &node{Value: "${INTO_42}", Next: cycle}
+	err = ExpandInto(cycle, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", cycle.Value)
+	assert.Equal(t, "42", cycle.Next.Value)
+
+	err = ExpandInto(cfg, lookup)
+	assert.EqualError(t, err, "destination must be a non-nil pointer")
+	err = ExpandInto((*config)(nil), lookup)
+	assert.EqualError(t, err, "destination must be a non-nil pointer")
+}
@@ -0,0 +1,137 @@
+package expandenv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ExpandJSON expands a JSON document including its object keys. Numbers are
+// kept exactly as written and characters like <, > and & are not escaped.
+// Data after the document is rejected.
+func ExpandJSON(input []byte, values VariableLookup) ([]byte, error) {
+	raw, err := decodeJSON(input)
+	if err != nil {
+		return nil, err
+	}
+	raw, expandErr := ExpandWithOptions(raw, values, Options{ExpandKeys: true})
+	output, err := encodeJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	return output, expandErr
+}
+
+// ExpandNDJSON expands newline delimited JSON line by line with ExpandJSON
+// and writes every line to w as soon as it is expanded. Blank lines are
+// kept. It stops at the first line that fails, so w holds all lines before
+// it.
+func ExpandNDJSON(r io.Reader, w io.Writer, values VariableLookup) error {
+	reader := bufio.NewReader(r)
+	for number := 1; ; number++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if readErr == io.EOF && len(line) == 0 {
+			return nil
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(bytes.TrimSpace(line)) > 0 {
+			expanded, err := ExpandJSON(line, values)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", number, err)
+			}
+			line = expanded
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+func decodeJSON(input []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return raw, nil
+}
+
+// encodeJSON is json.Marshal without escaping <, > and &, which would turn
+// expanded values like a&b into a\u0026b.
+func encodeJSON(value interface{}) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// embeddedJSON decodes str if it holds a JSON object or array.
+func embeddedJSON(str string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(str)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return nil, false
+	}
+	if !json.Valid([]byte(trimmed)) {
+		return nil, false
+	}
+	decoded, err := decodeJSON([]byte(trimmed))
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// jsonSafe converts value into a type that encodes to JSON without loss.
+func jsonSafe(value interface{}) (interface{}, bool) {
+	switch value := value.(type) {
+	case nil, string, bool, json.Number:
+		return value, true
+	case int:
+		return json.Number(strconv.Itoa(value)), true
+	case int64:
+		return json.Number(strconv.FormatInt(value, 10)), true
+	case float64:
+		return value, !math.IsNaN(value) && !math.IsInf(value, 0)
+	case FormattedFloat:
+		return json.Number(value.Text), !math.IsNaN(value.Value) && !math.IsInf(value.Value, 0)
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, v := range value {
+			safe, ok := jsonSafe(v)
+			if !ok {
+				return nil, false
+			}
+			result[i] = safe
+		}
+		return result, true
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			safe, ok := jsonSafe(v)
+			if !ok {
+				return nil, false
+			}
+			result[k] = safe
+		}
+		return result, true
+	}
+	return nil, false
+}
@@ -0,0 +1,165 @@
+package expandenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandJSON(t *testing.T) {
+	values := map[string]string{
+		"JSON_PREFIX": "user",
+		"JSON_OTHER":  "user",
+		"JSON_NAME":   "alice",
+		"JSON_42":     "42",
+	}
+
+	output, err := ExpandJSON([]byte(`{"${JSON_PREFIX}_id": 12345678901234567890, "name": "${JSON_NAME}", "age": "${JSON_42:number}", "nested": {"${JSON_PREFIX}": ["${JSON_NAME}"]}}`), lookupMap(values))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user_id": 12345678901234567890, "name": "alice", "age": 42, "nested": {"user": ["alice"]}}`, string(output))
+	assert.Contains(t, string(output), "12345678901234567890")
+
+	_, err = ExpandJSON([]byte(`{"nested": {"${JSON_PREFIX}": 1, "${JSON_OTHER}": 2}}`), lookupMap(values))
+	assert.EqualError(t, err, "at nested: keys ${JSON_OTHER} and ${JSON_PREFIX} both expand to 'user'")
+
+	_, err = ExpandJSON([]byte(`{"${JSON_42:number}": 1}`), lookupMap(values))
+	assert.EqualError(t, err, "key ${JSON_42:number} does not expand to a string")
+
+	_, err = ExpandJSON([]byte(`{"${JSON_UNKNOWN}": 1}`), lookupMap(values))
+	assert.EqualError(t, err, "at ${JSON_UNKNOWN}: variable JSON_UNKNOWN is missing")
+
+	output, err = ExpandJSON([]byte(`{"url": "${JSON_URL}"}`), lookupMap(map[string]string{"JSON_URL": "<a href=\"/?a=1&b=2\">"}))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"url":"<a href=\"/?a=1&b=2\">"}`, string(output))
+
+	_, err = ExpandJSON([]byte(`{"name": "${JSON_NAME}"} {"name": "x"}`), lookupMap(values))
+	assert.EqualError(t, err, "unexpected data after the JSON value")
+
+	_, err = ExpandJSON([]byte(`{"name": "${JSON_NAME}"}]`), lookupMap(values))
+	assert.EqualError(t, err, "unexpected data after the JSON value")
+
+	output, err = ExpandJSON([]byte(" {\"name\": \"${JSON_NAME}\"}\n"), lookupMap(values))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"alice"}`, string(output))
+}
+
+func TestExpandRawJSON(t *testing.T) {
+	values := map[string]string{
+		"RAW_NAME": "alice",
+		"RAW_42":   "42",
+	}
+	input := map[string]json.RawMessage{
+		"name":   json.RawMessage(`"${RAW_NAME}"`),
+		"nested": json.RawMessage(`{"age": "${RAW_42:number}", "tags": ["${RAW_NAME}", 12345678901234567890]}`),
+		"plain":  json.RawMessage(`{ "kept":  true }`),
+	}
+
+	output, err := ExpandWithOptions(input, lookupMap(values), Options{ExpandRawJSON: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{
+		"name":   json.RawMessage(`"alice"`),
+		"nested": json.RawMessage(`{"age":42,"tags":["alice",12345678901234567890]}`),
+		"plain":  json.RawMessage(`{ "kept":  true }`),
+	}, output)
+
+	output, err = ExpandWithOptions(input, lookupMap(values), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, input, output)
+
+	_, err = ExpandWithOptions(map[string]json.RawMessage{
+		"broken":   json.RawMessage(`{`),
+		"trailing": json.RawMessage(`{"a": "${RAW_NAME}"} {}`),
+		"unknown":  json.RawMessage(`{"a": "${RAW_UNKNOWN}"}`),
+	}, lookupMap(values), Options{ExpandRawJSON: true})
+	assert.EqualError(t, err, "at broken: unexpected EOF, at trailing: unexpected data after the JSON value, at unknown.a: variable RAW_UNKNOWN is missing")
+
+	output, err = ExpandWithOptions(map[string]json.RawMessage{
+		"query": json.RawMessage(`"${RAW_QUERY}"`),
+	}, lookupMap(map[string]string{"RAW_QUERY": "a<b&c"}), Options{ExpandRawJSON: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{"query": json.RawMessage(`"a<b&c"`)}, output)
+}
+
+func TestExpandJSONSafe(t *testing.T) {
+	values := map[string]string{
+		"SAFE_PORT":  "8080",
+		"SAFE_RATIO": "0.5",
+		"SAFE_NAN":   "NaN",
+		"SAFE_FLAG":  "yes",
+		"SAFE_WAIT":  "5s",
+	}
+	options := Options{
+		JSONSafe: true,
+		Formats: map[string]FormatFunc{
+			"duration": func(value string) (interface{}, error) {
+				return time.ParseDuration(value)
+			},
+		},
+	}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		error  error
+	}{
+		{input: "${SAFE_PORT:number}", output: json.Number("8080")},
+		{input: "${SAFE_RATIO:number}", output: 0.5},
+		{input: "${SAFE_FLAG:boolean}", output: true},
+		{input: "${SAFE_PORT}", output: "8080"},
+		{input: "${SAFE_UNKNOWN:-[]}", output: []interface{}{}},
+		{input: "${SAFE_NAN:number}", output: "${SAFE_NAN:number}", error: fmt.Errorf("format number produces float64, which cannot be represented in JSON")},
+		{input: "${SAFE_WAIT:duration}", output: "${SAFE_WAIT:duration}", error: fmt.Errorf("format duration produces time.Duration, which cannot be represented in JSON")},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := ExpandWithOptions(testCase.input, lookupMap(values), options)
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+
+	output, err := ExpandWithOptions(map[string]interface{}{"port": "${SAFE_PORT:number}"}, lookupMap(values), options)
+	assert.NoError(t, err)
+	encoded, err := json.Marshal(output)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"port":8080}`, string(encoded))
+}
+
+func TestExpandNDJSON(t *testing.T) {
+	values := map[string]string{
+		"NDJSON_LEVEL": "info",
+		"NDJSON_42":    "42",
+	}
+
+	output := bytes.Buffer{}
+	err := ExpandNDJSON(strings.NewReader("{\"level\": \"${NDJSON_LEVEL}\"}\r\n\n{\"count\": \"${NDJSON_42:number}\"}"), &output, lookupMap(values))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"level\":\"info\"}\n\n{\"count\":42}\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandNDJSON(strings.NewReader("{\"level\": \"${NDJSON_LEVEL}\"}\n{\"user\": \"${NDJSON_UNKNOWN}\"}\n{\"count\": 1}\n"), &output, lookupMap(values))
+	assert.EqualError(t, err, "line 2: at user: variable NDJSON_UNKNOWN is missing")
+	assert.Equal(t, "{\"level\":\"info\"}\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandNDJSON(strings.NewReader("{\"level\": 1}\n{invalid\n"), &output, lookupMap(values))
+	assert.EqualError(t, err, "line 2: invalid character 'i' looking for beginning of object key string")
+
+	output = bytes.Buffer{}
+	err = ExpandNDJSON(strings.NewReader("{\"level\": \"${NDJSON_LEVEL}\"} {\"level\": \"a&b\"}\n"), &output, lookupMap(values))
+	assert.EqualError(t, err, "line 1: unexpected data after the JSON value")
+
+	output = bytes.Buffer{}
+	err = ExpandNDJSON(strings.NewReader(""), &output, lookupMap(values))
+	assert.NoError(t, err)
+	assert.Equal(t, "", output.String())
+}
@@ -0,0 +1,98 @@
+package expandenv
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// LookupCaseInsensitive wraps base so that a name is first looked up as is,
+// then uppercased and finally lowercased. An exact match always wins, so with
+// both Path and PATH present ${Path} resolves to Path.
+func LookupCaseInsensitive(base VariableLookup) VariableLookup {
+	return func(key string) (*string, error) {
+		value, err := base(key)
+		if err == nil {
+			return value, nil
+		}
+		if upper := strings.ToUpper(key); upper != key {
+			if value, err := base(upper); err == nil {
+				return value, nil
+			}
+		}
+		if lower := strings.ToLower(key); lower != key {
+			if value, err := base(lower); err == nil {
+				return value, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// LookupDirFS resolves a variable NAME from the content of the file dir/NAME
+// in fsys, as used for secrets mounted as one file each. A single trailing
+// newline is removed.
+func LookupDirFS(fsys fs.FS, dir string) VariableLookup {
+	return func(key string) (*string, error) {
+		if !fs.ValidPath(key) || strings.Contains(key, "/") {
+			return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, key))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r")
+		return &value, nil
+	}
+}
+
+// LookupFromStruct resolves a variable from the exported field of v with the
+// same name, or with a matching `expandenv:"NAME"` tag. Fields tagged with
+// `expandenv:"-"` are ignored. v has to be a struct or a pointer to one.
+// Field values are formatted with fmt, nil pointers count as missing.
+func LookupFromStruct(v interface{}) VariableLookup {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return func(key string) (*string, error) {
+			return nil, fmt.Errorf("%T is not a struct", v)
+		}
+	}
+	fields := map[string][]int{}
+	for _, field := range reflect.VisibleFields(rv.Type()) {
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("expandenv"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		fields[name] = field.Index
+	}
+	return func(key string) (*string, error) {
+		index, ok := fields[key]
+		if !ok {
+			return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		field, err := rv.FieldByIndexErr(index)
+		for err == nil && field.Kind() == reflect.Pointer && !field.IsNil() {
+			field = field.Elem()
+		}
+		if err != nil || (field.Kind() == reflect.Pointer && field.IsNil()) {
+			return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		value := fmt.Sprintf("%v", field.Interface())
+		return &value, nil
+	}
+}
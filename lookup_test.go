@@ -0,0 +1,129 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupCaseInsensitive(t *testing.T) {
+	values := map[string]string{
+		"PATH":  "upper",
+		"Path":  "exact",
+		"home":  "lower",
+		"SHELL": "sh",
+	}
+	lookup := LookupCaseInsensitive(func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	})
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  "${Path}",
+			output: "exact",
+			label:  "exact-precedence",
+		},
+		{
+			input:  "${PATH}",
+			output: "upper",
+			label:  "exact",
+		},
+		{
+			input:  "${shell}",
+			output: "sh",
+			label:  "upper",
+		},
+		{
+			input:  "${HOME}",
+			output: "lower",
+			label:  "lower",
+		},
+		{
+			input:  "${UNKNOWN}",
+			output: "${UNKNOWN}",
+			label:  "unknown",
+			error:  fmt.Errorf("variable UNKNOWN is missing"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := Expand(testCase.input, lookup)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+}
+
+func TestLookupDirFS(t *testing.T) {
+	lookup := LookupDirFS(fstest.MapFS{
+		"run/secrets/DB_PASSWORD": {Data: []byte("s3cr3t\n")},
+		"run/secrets/API_KEY":     {Data: []byte("key\r\n")},
+		"run/OUTSIDE":             {Data: []byte("outside")},
+	}, "run/secrets")
+
+	output, err := Expand("${DB_PASSWORD}/${API_KEY}", lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t/key", output)
+
+	_, err = Expand("${UNKNOWN}", lookup)
+	assert.EqualError(t, err, "variable UNKNOWN is missing")
+
+	_, err = Expand("${../OUTSIDE}", lookup)
+	assert.EqualError(t, err, "variable ../OUTSIDE is missing")
+}
+
+func TestLookupFromStruct(t *testing.T) {
+	type embedded struct {
+		Region string
+	}
+	type config struct {
+		embedded
+		Host    string
+		Port    int
+		DB      string `expandenv:"DATABASE"`
+		Secret  string `expandenv:"-"`
+		Timeout *int
+		Retries *int
+		private string
+	}
+	timeout := 30
+	lookup := LookupFromStruct(&config{
+		embedded: embedded{Region: "eu"},
+		Host:     "db.local",
+		Port:     5432,
+		DB:       "app",
+		Secret:   "s3cr3t",
+		Timeout:  &timeout,
+		private:  "private",
+	})
+
+	output, err := Expand("${Host}:${Port}/${DATABASE}?timeout=${Timeout}&region=${Region}", lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "db.local:5432/app?timeout=30&region=eu", output)
+
+	output, err = Expand("${Port:number}", lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, 5432, output)
+
+	for _, name := range []string{"Unknown", "DB", "Secret", "Retries", "private"} {
+		_, err = Expand("${"+name+"}", lookup)
+		assert.EqualError(t, err, fmt.Sprintf("variable %s is missing", name), name)
+	}
+
+	_, err = Expand("${Host}", LookupFromStruct("text"))
+	assert.EqualError(t, err, "string is not a struct")
+}
@@ -0,0 +1,84 @@
+package expandenv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VarSpec declares a variable a template is expected to reference.
+type VarSpec struct {
+	// Type is a format like number or boolean the value must be valid for.
+	// An empty type accepts any value.
+	Type string
+	// Required rejects the expansion if the variable is missing, even if the
+	// placeholder has a fallback.
+	Required bool
+	// Default is used if the variable is missing.
+	Default *string
+}
+
+// ExpandWithManifest works like Expand but validates the template against
+// a manifest of expected variables. Placeholders referencing variables not
+// in the manifest and manifest entries that are never referenced are
+// reported as errors.
+func ExpandWithManifest(input interface{}, values VariableLookup, manifest map[string]VarSpec) (interface{}, error) {
+	errs := []error{}
+	for _, name := range sortedSpecNames(manifest) {
+		if !manifest[name].Required {
+			continue
+		}
+		if _, err := values(name); err != nil {
+			errs = append(errs, fmt.Errorf("variable %s is required", name))
+		}
+	}
+
+	lookup := func(name string) (*string, error) {
+		spec, ok := manifest[name]
+		if !ok {
+			return values(name)
+		}
+		value, err := values(name)
+		if err != nil {
+			if spec.Default == nil {
+				return nil, err
+			}
+			value = spec.Default
+		}
+		if value != nil {
+			if _, err := formatValue(*value, spec.Type, "", Options{}); err != nil {
+				return nil, fmt.Errorf("variable %s: %w", name, err)
+			}
+		}
+		return value, nil
+	}
+	result, err := ExpandWithResult(input, lookup, Options{})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	referenced := make([]string, 0, len(result.Stats))
+	for name := range result.Stats {
+		referenced = append(referenced, name)
+	}
+	sort.Strings(referenced)
+	for _, name := range referenced {
+		if _, ok := manifest[name]; !ok {
+			errs = append(errs, fmt.Errorf("variable %s is not declared in the manifest", name))
+		}
+	}
+	for _, name := range sortedSpecNames(manifest) {
+		if result.Stats[name].References == 0 {
+			errs = append(errs, fmt.Errorf("variable %s is declared in the manifest but never used", name))
+		}
+	}
+	return result.Output, joinErrors(errs)
+}
+
+func sortedSpecNames(manifest map[string]VarSpec) []string {
+	names := make([]string, 0, len(manifest))
+	for name := range manifest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,67 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandWithManifest(t *testing.T) {
+	values := map[string]string{
+		"MANIFEST_HOST": "db.local",
+		"MANIFEST_PORT": "5432",
+		"MANIFEST_NAME": "app",
+	}
+	defaultUser := "postgres"
+	manifest := map[string]VarSpec{
+		"MANIFEST_HOST": {Required: true},
+		"MANIFEST_PORT": {Type: "number"},
+		"MANIFEST_USER": {Default: &defaultUser},
+	}
+
+	testCases := []struct {
+		input    interface{}
+		manifest map[string]VarSpec
+		output   interface{}
+		error    error
+	}{
+		{
+			input:    "${MANIFEST_USER}@${MANIFEST_HOST}:${MANIFEST_PORT}",
+			manifest: manifest,
+			output:   "postgres@db.local:5432",
+		},
+		{
+			input:    "${MANIFEST_USER}@${MANIFEST_HOST}:${MANIFEST_PORT}/${MANIFEST_NAME:-db}",
+			manifest: manifest,
+			output:   "postgres@db.local:5432/app",
+			error:    fmt.Errorf("variable MANIFEST_NAME is not declared in the manifest"),
+		},
+		{
+			input:    "${MANIFEST_USER}@${MANIFEST_HOST}",
+			manifest: manifest,
+			output:   "postgres@db.local",
+			error:    fmt.Errorf("variable MANIFEST_PORT is declared in the manifest but never used"),
+		},
+		{
+			input: "${MANIFEST_DB:-app}:${MANIFEST_PORT}",
+			manifest: map[string]VarSpec{
+				"MANIFEST_DB":   {Required: true},
+				"MANIFEST_PORT": {Type: "boolean"},
+			},
+			output: "app:${MANIFEST_PORT}",
+			error:  fmt.Errorf("variable MANIFEST_DB is required, variable MANIFEST_PORT: 5432 is not a valid boolean"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := ExpandWithManifest(testCase.input, lookupMap(values), testCase.manifest)
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+}
@@ -0,0 +1,245 @@
+package expandenv
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NodeOptions controls which parts of a document ExpandNode touches. The
+// zero value expands values only and keeps comments and quoting.
+type NodeOptions struct {
+	// SkipValues leaves all values untouched, e.g. to only expand keys with
+	// Options.ExpandKeys.
+	SkipValues bool
+	// StripComments removes all comments from the document.
+	StripComments bool
+	// ResetQuoting drops the original quoting of expanded scalars, so they
+	// are only quoted if needed.
+	ResetQuoting bool
+	// Untyped keeps all results as strings, so ${PORT:number} becomes the
+	// string "8080" instead of an !!int.
+	Untyped bool
+	// CommentDefaults takes the fallback of a scalar consisting of a single
+	// placeholder without fallback from a trailing comment of the form
+	// "# default: value", e.g. port: ${PORT:number} # default: 80. Defaults
+	// that would not parse back as the whole fallback, like a}b, or that
+	// contain :- are reported as errors.
+	CommentDefaults bool
+}
+
+var commentDefaultRegex = regexp.MustCompile(`^#\s*default:\s*(.*?)\s*$`)
+
+// ExpandNode expands all scalars in a YAML node tree in place. Comments,
+// quoting and other formatting of the document are kept as is. Scalars that
+// expand to a non-string value take the kind and tag of that value, e.g.
+// ${PORT:number} becomes !!int, while all string results are tagged !!str.
+// With Options.ExpandKeys, mapping keys are expanded as well. See
+// NodeOptions for further toggles.
+//
+// Anchor names of nodes built in code may contain placeholders as well.
+// Aliases follow the expanded name of their anchor. Expanded anchor names
+// must be unique within the tree and must not contain whitespace or flow
+// indicators.
+func ExpandNode(node *yaml.Node, values VariableLookup, options Options) error {
+	errs := []error{}
+	anchors := map[string]string{}
+	var recursion func(node *yaml.Node, path string)
+	recursion = func(node *yaml.Node, path string) {
+		if options.FailFast && len(errs) > 0 {
+			return
+		}
+		if options.Node.StripComments {
+			node.HeadComment = ""
+			node.LineComment = ""
+			node.FootComment = ""
+		}
+		if node.Anchor != "" {
+			original := node.Anchor
+			if err := expandAnchor(node, values, options); err != nil {
+				errs = append(errs, err)
+			} else if origin, ok := anchors[node.Anchor]; ok && (origin != original || hasPlaceholders(original)) {
+				errs = append(errs, fmt.Errorf("anchors %s and %s both expand to '%s'", origin, original, node.Anchor))
+			} else {
+				anchors[node.Anchor] = original
+			}
+		}
+		switch node.Kind {
+		case yaml.AliasNode:
+			if node.Alias != nil {
+				node.Value = node.Alias.Anchor
+			}
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				recursion(child, path)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				recursion(child, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case yaml.MappingNode:
+			origins := map[string]string{}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i]
+				if options.Node.StripComments {
+					key.HeadComment = ""
+					key.LineComment = ""
+					key.FootComment = ""
+				}
+				if options.ExpandKeys && key.Kind == yaml.ScalarNode {
+					original := key.Value
+					if err := expandKeyNode(key, path, values, options); err != nil {
+						errs = append(errs, err)
+					} else if origin, ok := origins[key.Value]; ok {
+						errs = append(errs, atPath(path, keyCollisionError(origin, original, key.Value)))
+					} else {
+						origins[key.Value] = original
+					}
+				}
+				recursion(node.Content[i+1], joinPath(path, key.Value))
+			}
+		case yaml.ScalarNode:
+			if options.Node.SkipValues {
+				return
+			}
+			input := node.Value
+			if options.Node.CommentDefaults {
+				withDefault, err := withCommentDefault(node)
+				if err != nil {
+					errs = append(errs, atPath(path, err))
+					return
+				}
+				input = withDefault
+			}
+			if err := expandScalarNode(node, input, values, options); err != nil {
+				errs = append(errs, errorsAtPath(path, err)...)
+			}
+		}
+	}
+	recursion(node, "")
+	return joinErrors(errs)
+}
+
+// ExpandYAMLPreservingQuotes expands a YAML document while keeping the
+// original quoting style of every string, so "${PORT}" stays a quoted
+// string while ${PORT:number} becomes a plain number.
+func ExpandYAMLPreservingQuotes(input []byte, values VariableLookup) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(input, &node); err != nil {
+		return nil, err
+	}
+	if node.Kind == 0 {
+		return input, nil
+	}
+	expandErr := ExpandNode(&node, values, Options{})
+	output, err := yaml.Marshal(&node)
+	if err != nil {
+		return nil, err
+	}
+	return output, expandErr
+}
+
+// withCommentDefault adds the default given in the line comment of node as
+// fallback to its placeholder.
+func withCommentDefault(node *yaml.Node) (string, error) {
+	m := commentDefaultRegex.FindStringSubmatch(node.LineComment)
+	if m == nil || !isSinglePlaceholder(node.Value) {
+		return node.Value, nil
+	}
+	p, err := parsePlaceholder(node.Value)
+	if err != nil || p.hasFallback {
+		return node.Value, nil
+	}
+	input := node.Value[:len(node.Value)-1] + ":-" + m[1] + "}"
+	// The default is pasted into the placeholder as is, so it must not end
+	// the placeholder early or add another fallback.
+	if strings.Contains(m[1], ":-") || !isSinglePlaceholder(input) {
+		return "", fmt.Errorf("default %s in the comment is not a valid fallback", m[1])
+	}
+	if p, err := parsePlaceholder(input); err != nil || p.fallback != m[1] {
+		return "", fmt.Errorf("default %s in the comment is not a valid fallback", m[1])
+	}
+	return input, nil
+}
+
+func expandScalarNode(node *yaml.Node, input string, values VariableLookup, options Options) error {
+	expanded, err := ExpandWithOptions(input, values, options)
+	if err != nil {
+		return err
+	}
+	if expanded == node.Value {
+		return nil
+	}
+
+	if options.Node.ResetQuoting {
+		node.Style &^= yaml.DoubleQuotedStyle | yaml.SingleQuotedStyle
+	}
+	if _, ok := expanded.(string); !ok && options.Node.Untyped {
+		expanded = stringify(expanded)
+	}
+	if str, ok := expanded.(string); ok {
+		tagged := node.Style&yaml.TaggedStyle != 0
+		if options.PreserveTypes && tagged && node.Tag != "!!str" {
+			var decoded interface{}
+			check := yaml.Node{Kind: yaml.ScalarNode, Tag: node.Tag, Value: str}
+			if err := check.Decode(&decoded); err != nil {
+				return fmt.Errorf("%s is not a valid %s", str, node.Tag)
+			}
+		} else {
+			node.Tag = "!!str"
+		}
+		node.Style &^= yaml.TaggedStyle
+		node.Value = str
+		return nil
+	}
+
+	var encoded yaml.Node
+	if err := encoded.Encode(expanded); err != nil {
+		return err
+	}
+	node.Kind = encoded.Kind
+	node.Tag = encoded.Tag
+	node.Value = encoded.Value
+	node.Content = encoded.Content
+	node.Style = encoded.Style
+	return nil
+}
+
+func expandAnchor(node *yaml.Node, values VariableLookup, options Options) error {
+	if !hasPlaceholders(node.Anchor) {
+		return nil
+	}
+	expanded, err := ExpandWithOptions(node.Anchor, values, options)
+	if err != nil {
+		return err
+	}
+	str, ok := expanded.(string)
+	if !ok || str == "" || strings.ContainsAny(str, " \t\r\n,[]{}") {
+		return fmt.Errorf("anchor %s does not expand to a valid anchor name", node.Anchor)
+	}
+	node.Anchor = str
+	return nil
+}
+
+// expandKeyNode expands the key node of the mapping at path. Errors carry
+// the path like those of Options.ExpandKeys do.
+func expandKeyNode(node *yaml.Node, path string, values VariableLookup, options Options) error {
+	expanded, err := ExpandWithOptions(node.Value, values, options)
+	if err != nil {
+		return atPath(joinPath(path, node.Value), err)
+	}
+	str, ok := expanded.(string)
+	if !ok {
+		return atPath(path, fmt.Errorf("key %s does not expand to a string", node.Value))
+	}
+	if str != node.Value {
+		if options.Node.ResetQuoting {
+			node.Style &^= yaml.DoubleQuotedStyle | yaml.SingleQuotedStyle
+		}
+		node.Tag = "!!str"
+		node.Value = str
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandNode(t *testing.T) {
+	values := map[string]string{
+		"NODE_A":    "a",
+		"NODE_PORT": "8080",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+		label   string
+		error   error
+	}{
+		{
+			input:  "a: ${NODE_A} # comment\nb:\n    - ${NODE_A}\n    - 'static'\n",
+			output: "a: a # comment\nb:\n    - a\n    - 'static'\n",
+			label:  "strings",
+		},
+		{
+			input:  "port: ${NODE_PORT}\n",
+			output: "port: \"8080\"\n",
+			label:  "string-looking-like-int",
+		},
+		{
+			input:  "port: ${NODE_PORT:number}\n",
+			output: "port: 8080\n",
+			label:  "format",
+		},
+		{
+			input:   "port: !!int ${NODE_PORT}\n",
+			output:  "port: 8080\n",
+			options: Options{PreserveTypes: true},
+			label:   "preserve-types",
+		},
+		{
+			input:  "port: !!int ${NODE_PORT}\n",
+			output: "port: \"8080\"\n",
+			label:  "preserve-types-disabled",
+		},
+		{
+			input:   "port: !!int ${NODE_A}\n",
+			output:  "port: !!int ${NODE_A}\n",
+			options: Options{PreserveTypes: true},
+			label:   "preserve-types-invalid",
+			error:   fmt.Errorf("at port: a is not a valid !!int"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte(testCase.input), &node)
+		assert.NoError(t, err, testCase.label)
+		err = ExpandNode(&node, lookup, testCase.options)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		output, err := yaml.Marshal(&node)
+		assert.NoError(t, err, testCase.label)
+		assert.Equal(t, testCase.output, string(output), testCase.label)
+	}
+
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte("port: !!int ${NODE_PORT}\n"), &node)
+	assert.NoError(t, err)
+	err = ExpandNode(&node, lookup, Options{PreserveTypes: true})
+	assert.NoError(t, err)
+	var decoded map[string]interface{}
+	err = node.Decode(&decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"port": 8080}, decoded)
+}
+
+func TestExpandYAMLPreservingQuotes(t *testing.T) {
+	values := map[string]string{
+		"QUOTE_A":    "a",
+		"QUOTE_PORT": "8080",
+	}
+
+	output, err := ExpandYAMLPreservingQuotes([]byte(`plain: ${QUOTE_A}
+double: "${QUOTE_A}"
+single: '${QUOTE_A}'
+port: "${QUOTE_PORT}"
+number: ${QUOTE_PORT:number}
+quoted-number: "${QUOTE_PORT:number}"
+unknown: "${QUOTE_UNKNOWN}"
+`), func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	})
+	assert.EqualError(t, err, "at unknown: variable QUOTE_UNKNOWN is missing")
+	assert.Equal(t, `plain: a
+double: "a"
+single: 'a'
+port: "8080"
+number: 8080
+quoted-number: 8080
+unknown: "${QUOTE_UNKNOWN}"
+`, string(output))
+
+	output, err = ExpandYAMLPreservingQuotes([]byte{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", string(output))
+}
+
+func TestExpandNodeTags(t *testing.T) {
+	values := map[string]string{
+		"TAG_PORT":  "8080",
+		"TAG_RATIO": "0.5",
+		"TAG_FLAG":  "yes",
+	}
+
+	testCases := []struct {
+		input   string
+		tag     string
+		value   string
+		options Options
+	}{
+		{input: "${TAG_PORT:number}", tag: "!!int", value: "8080"},
+		{input: "'${TAG_PORT:number}'", tag: "!!int", value: "8080"},
+		{input: "${TAG_RATIO:number}", tag: "!!float", value: "0.5"},
+		{input: "${TAG_RATIO:number}", tag: "!!float", value: "0.50", options: Options{FloatFormat: FloatFormat{Format: 'f', Precision: 2}}},
+		{input: "${TAG_FLAG:boolean}", tag: "!!bool", value: "true"},
+		{input: "${TAG_PORT}", tag: "!!str", value: "8080"},
+		{input: "${TAG_PORT:string}", tag: "!!str", value: "8080"},
+		{input: "${TAG_PORT:number}0", tag: "!!str", value: "80800"},
+		{input: "${TAG_UNKNOWN:number:-443}", tag: "!!int", value: "443"},
+		{input: "${TAG_UNKNOWN:-[]}", tag: "!!seq", value: ""},
+	}
+
+	for _, testCase := range testCases {
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte("value: "+testCase.input+"\n"), &node)
+		assert.NoError(t, err, testCase.input)
+		err = ExpandNode(&node, lookupMap(values), testCase.options)
+		assert.NoError(t, err, testCase.input)
+		scalar := node.Content[0].Content[1]
+		assert.Equal(t, testCase.tag, scalar.Tag, testCase.input)
+		assert.Equal(t, testCase.value, scalar.Value, testCase.input)
+	}
+}
+
+func TestExpandNodeToggles(t *testing.T) {
+	values := map[string]string{
+		"TOGGLE_KEY":  "name",
+		"TOGGLE_A":    "a",
+		"TOGGLE_PORT": "8080",
+	}
+	input := `# settings
+${TOGGLE_KEY}: "${TOGGLE_A}" # the name
+port: ${TOGGLE_PORT:number}
+`
+
+	testCases := []struct {
+		options Options
+		output  string
+		label   string
+	}{
+		{
+			options: Options{},
+			output:  "# settings\n${TOGGLE_KEY}: \"a\" # the name\nport: 8080\n",
+			label:   "default",
+		},
+		{
+			options: Options{ExpandKeys: true},
+			output:  "# settings\nname: \"a\" # the name\nport: 8080\n",
+			label:   "keys",
+		},
+		{
+			options: Options{ExpandKeys: true, Node: NodeOptions{SkipValues: true}},
+			output:  "# settings\nname: \"${TOGGLE_A}\" # the name\nport: ${TOGGLE_PORT:number}\n",
+			label:   "keys-only",
+		},
+		{
+			options: Options{Node: NodeOptions{StripComments: true}},
+			output:  "${TOGGLE_KEY}: \"a\"\nport: 8080\n",
+			label:   "strip-comments",
+		},
+		{
+			options: Options{Node: NodeOptions{ResetQuoting: true}},
+			output:  "# settings\n${TOGGLE_KEY}: a # the name\nport: 8080\n",
+			label:   "reset-quoting",
+		},
+		{
+			options: Options{Node: NodeOptions{Untyped: true}},
+			output:  "# settings\n${TOGGLE_KEY}: \"a\" # the name\nport: \"8080\"\n",
+			label:   "untyped",
+		},
+	}
+
+	for _, testCase := range testCases {
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte(input), &node)
+		assert.NoError(t, err, testCase.label)
+		err = ExpandNode(&node, lookupMap(values), testCase.options)
+		assert.NoError(t, err, testCase.label)
+		output, err := yaml.Marshal(&node)
+		assert.NoError(t, err, testCase.label)
+		assert.Equal(t, testCase.output, string(output), testCase.label)
+	}
+
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte("${TOGGLE_A}: 1\na: 2\n${TOGGLE_PORT:number}: 3\n"), &node)
+	assert.NoError(t, err)
+	err = ExpandNode(&node, lookupMap(values), Options{ExpandKeys: true})
+	assert.EqualError(t, err, "keys ${TOGGLE_A} and a both expand to 'a', key ${TOGGLE_PORT:number} does not expand to a string")
+
+	err = yaml.Unmarshal([]byte("spec:\n  labels:\n    ${TOGGLE_A}: 1\n    a: 2\n    ${TOGGLE_PORT:number}: 3\n  list:\n    - ${TOGGLE_UNKNOWN}: 4\n"), &node)
+	assert.NoError(t, err)
+	err = ExpandNode(&node, lookupMap(values), Options{ExpandKeys: true})
+	assert.EqualError(t, err, "at spec.labels: keys ${TOGGLE_A} and a both expand to 'a', at spec.labels: key ${TOGGLE_PORT:number} does not expand to a string, at spec.list[0].${TOGGLE_UNKNOWN}: variable TOGGLE_UNKNOWN is missing")
+
+	err = yaml.Unmarshal([]byte("spec:\n  list:\n    - ${TOGGLE_A} ${TOGGLE_UNKNOWN}\n  port: ${TOGGLE_A:number}\n"), &node)
+	assert.NoError(t, err)
+	err = ExpandNode(&node, lookupMap(values), Options{})
+	assert.EqualError(t, err, "at spec.list[0]: variable TOGGLE_UNKNOWN is missing, at spec.port: a is not a valid number")
+}
+
+func TestExpandNodeCommentDefaults(t *testing.T) {
+	values := map[string]string{
+		"COMMENT_HOST": "db.local",
+	}
+	input := `host: ${COMMENT_HOST} # default: localhost
+port: ${COMMENT_PORT:number} # default: 5432
+name: ${COMMENT_NAME} #default:  app
+user: ${COMMENT_USER:-admin} # default: root
+mode: ${COMMENT_MODE} # the mode
+brace: ${COMMENT_BRACE} # default: a}b
+open: ${COMMENT_OPEN} # default: a{b
+nested: ${COMMENT_NESTED} # default: a:-b
+`
+
+	var node yaml.Node
+	err := yaml.Unmarshal([]byte(input), &node)
+	assert.NoError(t, err)
+	err = ExpandNode(&node, lookupMap(values), Options{Node: NodeOptions{CommentDefaults: true}})
+	assert.EqualError(t, err, "at brace: default a}b in the comment is not a valid fallback, at mode: variable COMMENT_MODE is missing, at nested: default a:-b in the comment is not a valid fallback")
+	output, err := yaml.Marshal(&node)
+	assert.NoError(t, err)
+	assert.Equal(t, `host: db.local # default: localhost
+port: 5432 # default: 5432
+name: app #default:  app
+user: admin # default: root
+mode: ${COMMENT_MODE} # the mode
+brace: ${COMMENT_BRACE} # default: a}b
+open: a{b # default: a{b
+nested: ${COMMENT_NESTED} # default: a:-b
+`, string(output))
+
+	err = yaml.Unmarshal([]byte(input), &node)
+	assert.NoError(t, err)
+	err = ExpandNode(&node, lookupMap(values), Options{})
+	assert.EqualError(t, err, "at brace: variable COMMENT_BRACE is missing, at mode: variable COMMENT_MODE is missing, at name: variable COMMENT_NAME is missing, at nested: variable COMMENT_NESTED is missing, at open: variable COMMENT_OPEN is missing, at port: variable COMMENT_PORT is missing")
+}
+
+func TestExpandNodeAnchors(t *testing.T) {
+	values := map[string]string{
+		"NODE_ENV": "prod",
+	}
+	parse := func(input string, anchors ...string) *yaml.Node {
+		var node yaml.Node
+		err := yaml.Unmarshal([]byte(input), &node)
+		assert.NoError(t, err)
+		for i, anchor := range anchors {
+			node.Content[0].Content[2*i+1].Anchor = anchor
+		}
+		return &node
+	}
+
+	node := parse("base: &base\n    host: db\nderived:\n    <<: *base\n    port: 1\nalias: *base\n", "base-${NODE_ENV}")
+	err := ExpandNode(node, lookupMap(values), Options{})
+	assert.NoError(t, err)
+	output, err := yaml.Marshal(node)
+	assert.NoError(t, err)
+	assert.Equal(t, "base: &base-prod\n    host: db\nderived:\n    !!merge <<: *base-prod\n    port: 1\nalias: *base-prod\n", string(output))
+	var decoded map[string]interface{}
+	err = node.Decode(&decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"host": "db", "port": 1}, decoded["derived"])
+
+	node = parse("a: &a 1\nb: &b 2\n", "x-${NODE_ENV}", "x-prod")
+	err = ExpandNode(node, lookupMap(values), Options{})
+	assert.EqualError(t, err, "anchors x-${NODE_ENV} and x-prod both expand to 'x-prod'")
+
+	node = parse("a: &a 1\nb: &b 2\n", "x-${NODE_ENV}", "x-${NODE_ENV}")
+	err = ExpandNode(node, lookupMap(values), Options{})
+	assert.EqualError(t, err, "anchors x-${NODE_ENV} and x-${NODE_ENV} both expand to 'x-prod'")
+
+	node = parse("a: &a 1\n", "${NODE_SPACED:-a b}")
+	err = ExpandNode(node, lookupMap(values), Options{})
+	assert.EqualError(t, err, "anchor ${NODE_SPACED:-a b} does not expand to a valid anchor name")
+}
@@ -0,0 +1,44 @@
+package expandenv
+
+type EventKind string
+
+const (
+	// EventReference is emitted whenever a variable is about to be looked up.
+	EventReference EventKind = "reference"
+	// EventResolved is emitted when a variable was found by the lookup.
+	EventResolved EventKind = "resolved"
+	// EventFallback is emitted when a missing variable falls back to its
+	// default value.
+	EventFallback EventKind = "fallback"
+	// EventFormat is emitted after a format has been applied.
+	EventFormat EventKind = "format"
+	// EventError is emitted for every error encountered.
+	EventError EventKind = "error"
+)
+
+type Event struct {
+	Kind   EventKind
+	Name   string
+	Format string
+	Value  interface{}
+	Err    error
+	// Sensitive is set for variables marked with ${NAME:secret}. Their
+	// Value is always reported as ***.
+	Sensitive bool
+}
+
+// Observer receives events during an expansion, e.g. to forward them to a
+// logger.
+type Observer = func(event Event)
+
+func (o Options) notify(event Event) {
+	if o.Observer != nil {
+		if o.sensitive {
+			event.Sensitive = true
+			if event.Value != nil {
+				event.Value = "***"
+			}
+		}
+		o.Observer(event)
+	}
+}
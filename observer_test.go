@@ -0,0 +1,80 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandWithObserver(t *testing.T) {
+	values := map[string]string{
+		"OBS_A":  "a",
+		"OBS_42": "42",
+	}
+	events := []Event{}
+	options := Options{
+		Observer: func(event Event) {
+			events = append(events, event)
+		},
+	}
+
+	_, err := ExpandWithOptions([]interface{}{
+		"${OBS_A}",
+		"${OBS_42:number}",
+		"${OBS_UNKNOWN:-fallback}",
+		"${OBS_UNKNOWN}",
+	}, func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		return &value, nil
+	}, options)
+	assert.EqualError(t, err, "at [3]: variable OBS_UNKNOWN is missing")
+	assert.Equal(t, []Event{
+		{Kind: EventReference, Name: "OBS_A"},
+		{Kind: EventResolved, Name: "OBS_A", Value: "a"},
+		{Kind: EventReference, Name: "OBS_42"},
+		{Kind: EventResolved, Name: "OBS_42", Value: "42"},
+		{Kind: EventFormat, Name: "OBS_42", Format: "number", Value: 42},
+		{Kind: EventReference, Name: "OBS_UNKNOWN"},
+		{Kind: EventFallback, Name: "OBS_UNKNOWN", Value: "fallback"},
+		{Kind: EventReference, Name: "OBS_UNKNOWN"},
+		{Kind: EventError, Err: &PathError{Path: "[3]", Err: &MissingError{Name: "OBS_UNKNOWN", Err: fmt.Errorf("variable OBS_UNKNOWN is missing")}}},
+	}, events)
+}
+
+func TestExpandWithObserverSecrets(t *testing.T) {
+	values := map[string]string{
+		"OBS_TOKEN": "s3cr3t",
+		"OBS_PIN":   "1234x",
+	}
+	events := []Event{}
+	options := Options{
+		Observer: func(event Event) {
+			events = append(events, event)
+		},
+	}
+
+	output, err := ExpandWithOptions([]interface{}{
+		"Bearer ${OBS_TOKEN:secret}",
+		"${OBS_UNKNOWN:secret:-d3f4ult}",
+		"${OBS_PIN:secret:number}",
+	}, lookupMap(values), options)
+	assert.EqualError(t, err, "at [2]: variable OBS_PIN: *** is not a valid number")
+	assert.Equal(t, []interface{}{"Bearer s3cr3t", "d3f4ult", "${OBS_PIN:secret:number}"}, output)
+	assert.Equal(t, []Event{
+		{Kind: EventReference, Name: "OBS_TOKEN", Sensitive: true},
+		{Kind: EventResolved, Name: "OBS_TOKEN", Value: "***", Sensitive: true},
+		{Kind: EventReference, Name: "OBS_UNKNOWN", Sensitive: true},
+		{Kind: EventFallback, Name: "OBS_UNKNOWN", Value: "***", Sensitive: true},
+		{Kind: EventReference, Name: "OBS_PIN", Sensitive: true},
+		{Kind: EventResolved, Name: "OBS_PIN", Value: "***", Sensitive: true},
+		{Kind: EventError, Err: err.(*ExpandError).Errors[0]},
+	}, events)
+
+	result, err := ExpandWithResult("${OBS_UNKNOWN:secret:-d3f4ult}", lookupMap(values), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []FallbackUsage{{Name: "OBS_UNKNOWN", Value: "***"}}, result.Fallbacks)
+}
@@ -0,0 +1,26 @@
+package expandenv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// parsePercent parses percentages like 25, 12.5 or 25% within 0..100.
+func parsePercent(value string) (float64, bool) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, false
+	}
+	return percent, true
+}
+
+// inRollout tells whether seed falls into the first percent of all buckets.
+// The bucket is taken from the SHA-256 of seed, so the same seed always
+// gets the same answer and raising the percentage only ever adds seeds.
+func inRollout(seed string, percent float64) bool {
+	sum := sha256.Sum256([]byte(seed))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % 10000
+	return float64(bucket) < percent*100
+}
@@ -0,0 +1,50 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPercent(t *testing.T) {
+	values := map[string]string{
+		"ROLLOUT_NONE": "0",
+		"ROLLOUT_ALL":  "100%",
+		"ROLLOUT_SOME": "30",
+		"ROLLOUT_BAD":  "120",
+		"USER":         "alice",
+	}
+
+	output, err := ExpandMap("${ROLLOUT_SOME:percent:seed=USER}", values)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		again, err := ExpandMap("${ROLLOUT_SOME:percent:seed=USER}", values)
+		assert.NoError(t, err)
+		assert.Equal(t, output, again)
+	}
+
+	output, err = ExpandMap([]interface{}{"${ROLLOUT_NONE:percent:seed=USER}", "${ROLLOUT_ALL:percent:seed=USER}", "${ROLLOUT_UNKNOWN:percent:seed=USER:-100}"}, values)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{false, true, true}, output)
+
+	enabled := 0
+	for i := 0; i < 2000; i++ {
+		values["USER"] = fmt.Sprintf("user-%d", i)
+		output, err := ExpandMap("${ROLLOUT_SOME:percent:seed=USER}", values)
+		assert.NoError(t, err)
+		if output == true {
+			enabled++
+		}
+	}
+	assert.InDelta(t, 600, enabled, 80)
+
+	_, err = ExpandMap("${ROLLOUT_SOME:percent}", values)
+	assert.EqualError(t, err, "format percent needs a seed like percent:seed=USER")
+
+	_, err = ExpandMap("${ROLLOUT_BAD:percent:seed=USER}", values)
+	assert.EqualError(t, err, "120 is not a valid percentage")
+
+	_, err = ExpandMap("${ROLLOUT_SOME:percent:seed=UNKNOWN_USER}", values)
+	assert.EqualError(t, err, "variable UNKNOWN_USER is missing")
+}
@@ -0,0 +1,41 @@
+package expandenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isPipe tells whether a placeholder uses the pipe syntax. Placeholders in
+// colon syntax may still contain pipes in their fallback, e.g. ${X:-a|b}.
+func isPipe(str string) bool {
+	head, _, found := strings.Cut(str[2:len(str)-1], "|")
+	return found && !strings.Contains(head, ":")
+}
+
+// parsePipe parses a placeholder in pipe syntax like ${VAR | trim |
+// default:x} into the structure of the colon syntax. Filters become
+// modifiers and a default becomes the fallback. Filters before the default
+// only apply to a resolved value, not to the fallback.
+func parsePipe(str string) (placeholder, error) {
+	segments := strings.Split(str[2:len(str)-1], "|")
+	result := placeholder{name: strings.TrimSpace(segments[0])}
+	if result.name == "" {
+		return result, errEmptyName
+	}
+	for _, segment := range segments[1:] {
+		filter, arg, hasArg := strings.Cut(strings.TrimSpace(segment), ":")
+		if filter == "" || (filter == "default" && result.hasFallback) {
+			return result, fmt.Errorf("could not parse %s", str)
+		}
+		if filter == "default" {
+			result.hasFallback = true
+			result.fallback = arg
+			for i := range result.modifiers {
+				result.modifiers[i].valueOnly = true
+			}
+			continue
+		}
+		result.modifiers = append(result.modifiers, modifier{name: filter, arg: arg, hasArg: hasArg})
+	}
+	return result, nil
+}
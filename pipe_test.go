@@ -0,0 +1,133 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandWithPipeSyntax(t *testing.T) {
+	values := map[string]string{
+		"PIPE_NAME": "  Hello World  ",
+		"PIPE_42":   " 42 ",
+		"PIPE_REF":  "PIPE_42",
+		"PIPE_PCT":  "100",
+		"PIPE_USER": "jane",
+		"PIPE_HOST": "db.local",
+	}
+	options := Options{PipeSyntax: true}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  "${PIPE_NAME | trim | lower}",
+			output: "hello world",
+			label:  "filters",
+		},
+		{
+			input:  "${PIPE_UNKNOWN | trim | lower | default:Fallback}",
+			output: "Fallback",
+			label:  "default",
+		},
+		{
+			input:  "${PIPE_UNKNOWN | default: Padded  | trim | upper}",
+			output: "PADDED",
+			label:  "default-then-filters",
+		},
+		{
+			input:  "${PIPE_NAME | trim | default:unused | upper}",
+			output: "HELLO WORLD",
+			label:  "default-unused",
+		},
+		{
+			input:  "${PIPE_42 | trim | number}",
+			output: 42,
+			label:  "format",
+		},
+		{
+			input:  "${PIPE_42 | trim | count:1..5}",
+			output: "${PIPE_42 | trim | count:1..5}",
+			label:  "format-with-argument",
+			error:  fmt.Errorf("42 is not within 1..5"),
+		},
+		{
+			input:  "${PIPE_42 | trim | number | lower}",
+			output: "${PIPE_42 | trim | number | lower}",
+			label:  "non-string-input",
+			error:  fmt.Errorf("format lower needs a string input"),
+		},
+		{
+			input:  "${PIPE_UNKNOWN | trim}",
+			output: "${PIPE_UNKNOWN | trim}",
+			label:  "unknown",
+			error:  fmt.Errorf("variable PIPE_UNKNOWN is missing"),
+		},
+		{
+			input:  "${PIPE_PCT | seed:PIPE_USER | percent}",
+			output: true,
+			label:  "seed-marker",
+		},
+		{
+			input:  "${PIPE_UNKNOWN | coalesce:PIPE_UNSET,PIPE_HOST | upper}",
+			output: "DB.LOCAL",
+			label:  "coalesce-marker",
+		},
+		{
+			input:  "${PIPE_NAME | secret | number}",
+			output: "${PIPE_NAME | secret | number}",
+			label:  "secret-marker",
+			error:  fmt.Errorf("variable PIPE_NAME: *** is not a valid number"),
+		},
+		{
+			input:  "${!PIPE_REF | trim}",
+			output: "42",
+			label:  "indirect",
+		},
+		{
+			input:  "${PIPE_UNKNOWN | default:[]}",
+			output: []interface{}{},
+			label:  "empty-list-default",
+		},
+		{
+			input:  "${PIPE_UNKNOWN | default:${PIPE_HOST}}",
+			output: "db.local",
+			label:  "nested-default",
+		},
+		{
+			input:  "${PIPE_UNKNOWN | default:!err PIPE_UNKNOWN is required}",
+			output: "${PIPE_UNKNOWN | default:!err PIPE_UNKNOWN is required}",
+			label:  "error-default",
+			error:  fmt.Errorf("PIPE_UNKNOWN is required"),
+		},
+		{
+			input:  "${PIPE_UNKNOWN | default:a | default:b}",
+			output: "${PIPE_UNKNOWN | default:a | default:b}",
+			label:  "two-defaults",
+			error:  fmt.Errorf("could not parse ${PIPE_UNKNOWN | default:a | default:b}"),
+		},
+		{
+			input:  "${PIPE_NAME:-a|b}",
+			output: "  Hello World  ",
+			label:  "colon-syntax",
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := ExpandWithOptions(testCase.input, lookupMap(values), options)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+
+	output, err := Expand("${PIPE_UNKNOWN:-a|b}", lookupMap(values))
+	assert.NoError(t, err)
+	assert.Equal(t, "a|b", output)
+}
@@ -0,0 +1,315 @@
+package expandenv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var errEmptyName = errors.New("empty variable name")
+
+var modifierRegex = regexp.MustCompile(`^([a-z][a-z0-9-]*)(?:(=)(.*))?$`)
+
+type placeholder struct {
+	name        string
+	modifiers   []modifier
+	hasFallback bool
+	fallback    string
+}
+
+type modifier struct {
+	name   string
+	arg    string
+	hasArg bool
+	// valueOnly modifiers are not applied to the fallback, as with filters
+	// before the default in pipe syntax.
+	valueOnly bool
+}
+
+// parsePlaceholder splits a placeholder like ${URL:trimprefix=https://:upper:-x}
+// into its name, modifier chain and fallback. Segments that do not look like
+// a modifier belong to the argument of the preceding one, so arguments may
+// contain colons. A colon followed by something that looks like a modifier,
+// as in trimprefix=a:b, has to be escaped as \: instead.
+func parsePlaceholder(str string) (placeholder, error) {
+	result := placeholder{}
+	body := str[2 : len(str)-1]
+	name, rest, _ := strings.Cut(body, ":")
+	if name == "" {
+		return result, errEmptyName
+	}
+	result.name = name
+	if len(body) > len(name) {
+		rest = body[len(name):]
+	}
+	if i := indexUnescaped(rest, ":-"); i >= 0 {
+		result.hasFallback = true
+		result.fallback = rest[i+2:]
+		rest = rest[:i]
+	}
+	if rest == "" {
+		return result, nil
+	}
+	for _, segment := range splitUnescaped(rest[1:]) {
+		if m := modifierRegex.FindStringSubmatch(segment); m != nil {
+			result.modifiers = append(result.modifiers, modifier{name: m[1], arg: unescapeColons(m[3]), hasArg: m[2] != ""})
+			continue
+		}
+		if len(result.modifiers) == 0 || !result.modifiers[len(result.modifiers)-1].hasArg {
+			return result, fmt.Errorf("could not parse %s", str)
+		}
+		result.modifiers[len(result.modifiers)-1].arg += ":" + unescapeColons(segment)
+	}
+	return result, nil
+}
+
+// parseWithOptions parses a placeholder in colon syntax, or in pipe syntax
+// if enabled by options.PipeSyntax.
+func parseWithOptions(str string, options Options) (placeholder, error) {
+	if options.PipeSyntax && isPipe(str) {
+		return parsePipe(str)
+	}
+	return parsePlaceholder(str)
+}
+
+// appliesToFallback tells whether any of the modifiers is applied to the
+// fallback.
+func appliesToFallback(modifiers []modifier) bool {
+	for _, m := range modifiers {
+		if !m.valueOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// markers are the modifiers of a placeholder that change how its variable
+// is looked up instead of formatting the value.
+type markers struct {
+	env          bool
+	secret       bool
+	seed         string
+	alternatives []string
+}
+
+// splitMarkers separates the markers env, secret, seed=NAME and
+// coalesce=A,B from the formats of a placeholder.
+func splitMarkers(modifiers []modifier) (markers, []modifier, error) {
+	result := markers{}
+	formats := []modifier{}
+	for _, m := range modifiers {
+		switch {
+		case m.name == "env" && !m.hasArg:
+			result.env = true
+		case m.name == "secret" && !m.hasArg:
+			result.secret = true
+		case m.name == "seed":
+			if m.arg == "" {
+				return result, nil, fmt.Errorf("seed needs a variable name like seed=USER")
+			}
+			result.seed = m.arg
+		case m.name == "coalesce":
+			for _, alternative := range strings.Split(m.arg, ",") {
+				if alternative = strings.TrimSpace(alternative); alternative != "" {
+					result.alternatives = append(result.alternatives, alternative)
+				}
+			}
+			if len(result.alternatives) == 0 {
+				return result, nil, fmt.Errorf("coalesce needs variable names like coalesce=A,B")
+			}
+		default:
+			formats = append(formats, m)
+		}
+	}
+	return result, formats, nil
+}
+
+// isSecretPlaceholder tells whether a placeholder carries the secret marker.
+func isSecretPlaceholder(str string, options Options) bool {
+	p, err := parseWithOptions(str, options)
+	if err != nil {
+		return false
+	}
+	markers, _, err := splitMarkers(p.modifiers)
+	return err == nil && markers.secret
+}
+
+// indexUnescaped returns the index of the first occurrence of sep in str
+// that is not preceded by an escaping backslash, or -1.
+func indexUnescaped(str string, sep string) int {
+	for i := 0; i+len(sep) <= len(str); i++ {
+		if str[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(str[i:], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnescaped splits str at every colon not escaped with a backslash.
+func splitUnescaped(str string) []string {
+	result := []string{}
+	for {
+		i := indexUnescaped(str, ":")
+		if i < 0 {
+			return append(result, str)
+		}
+		result = append(result, str[:i])
+		str = str[i+1:]
+	}
+}
+
+func unescapeColons(str string) string {
+	return strings.ReplaceAll(str, "\\:", ":")
+}
+
+// Parsed describes how a single placeholder is interpreted.
+type Parsed struct {
+	Name string
+	// Format is the modifier chain as written, e.g. trimprefix=https://:upper.
+	Format    string
+	Modifiers []Modifier
+	Indirect  bool
+	Env       bool
+	Secret    bool
+	// Seed is the variable given by seed=NAME.
+	Seed string
+	// Alternatives are the variables given by coalesce=A,B.
+	Alternatives []string
+	HasFallback  bool
+	Fallback     string
+}
+
+// Modifier is a single format of a placeholder's modifier chain.
+type Modifier struct {
+	Name   string
+	Arg    string
+	HasArg bool
+}
+
+// Explain parses a single placeholder like ${NAME:number:-42} without
+// resolving anything. The markers env, secret, seed and coalesce are
+// reported separately and are not part of the modifier chain.
+func Explain(str string) (Parsed, error) {
+	if !isSinglePlaceholder(str) {
+		return Parsed{}, fmt.Errorf("%s is not a single placeholder", str)
+	}
+	return explain(str)
+}
+
+// explain describes a placeholder found by findPlaceholders like Explain.
+func explain(str string) (Parsed, error) {
+	p, err := parsePlaceholder(str)
+	if err != nil {
+		return Parsed{}, err
+	}
+	markers, modifiers, err := splitMarkers(p.modifiers)
+	if err != nil {
+		return Parsed{}, err
+	}
+	result := Parsed{
+		Name:         strings.TrimPrefix(p.name, "!"),
+		Format:       formatChain(modifiers),
+		Indirect:     strings.HasPrefix(p.name, "!"),
+		Env:          markers.env,
+		Secret:       markers.secret,
+		Seed:         markers.seed,
+		Alternatives: markers.alternatives,
+		HasFallback:  p.hasFallback,
+		Fallback:     p.fallback,
+	}
+	for _, m := range modifiers {
+		result.Modifiers = append(result.Modifiers, Modifier{Name: m.name, Arg: m.arg, HasArg: m.hasArg})
+	}
+	return result, nil
+}
+
+// formatChain renders modifiers as written, e.g. trimprefix=https://:upper.
+func formatChain(modifiers []modifier) string {
+	formats := []string{}
+	for _, m := range modifiers {
+		if m.hasArg {
+			formats = append(formats, m.name+"="+m.arg)
+		} else {
+			formats = append(formats, m.name)
+		}
+	}
+	return strings.Join(formats, ":")
+}
+
+// findPlaceholders returns the start and end offsets of all placeholders in
+// str, each including a leading escaping backslash if present. Balanced
+// braces inside a placeholder are part of it, so ${MAP:-{}} is a single
+// placeholder. If they are not balanced, the placeholder ends at the first
+// closing brace, so ${X:-x{} has the fallback x{.
+func findPlaceholders(str string) [][2]int {
+	result := [][2]int{}
+	for i := 0; i < len(str); i++ {
+		start := i
+		if str[i] == '\\' && strings.HasPrefix(str[i+1:], "${") {
+			i++
+		} else if !strings.HasPrefix(str[i:], "${") {
+			continue
+		}
+		depth := 0
+		end := -1
+		for j := i + 2; j < len(str) && end < 0; j++ {
+			switch str[j] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					end = j + 1
+				}
+				depth--
+			}
+		}
+		if end < 0 {
+			end = strings.IndexByte(str[i+2:], '}') + i + 3
+		}
+		if end < i+3 {
+			i = start
+			continue
+		}
+		result = append(result, [2]int{start, end})
+		i = end - 1
+	}
+	return result
+}
+
+func replacePlaceholders(str string, fn func(placeholder string) string) string {
+	result := strings.Builder{}
+	last := 0
+	for _, match := range findPlaceholders(str) {
+		result.WriteString(str[last:match[0]])
+		result.WriteString(fn(str[match[0]:match[1]]))
+		last = match[1]
+	}
+	result.WriteString(str[last:])
+	return result.String()
+}
+
+func isSinglePlaceholder(str string) bool {
+	matches := findPlaceholders(str)
+	return len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(str) && str[0] != '\\'
+}
+
+func hasPlaceholders(str string) bool {
+	return len(findPlaceholders(str)) > 0
+}
+
+// stringify renders a value embedded into a longer string.
+func stringify(value interface{}) string {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		if encoded, err := json.Marshal(value); err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,101 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output Parsed
+		label  string
+		error  error
+	}{
+		{
+			input:  "${HOST}",
+			output: Parsed{Name: "HOST"},
+			label:  "plain",
+		},
+		{
+			input: "${PORT:number:-5432}",
+			output: Parsed{
+				Name:        "PORT",
+				Format:      "number",
+				Modifiers:   []Modifier{{Name: "number"}},
+				HasFallback: true,
+				Fallback:    "5432",
+			},
+			label: "format-fallback",
+		},
+		{
+			input: "${URL:trimprefix=https://:upper}",
+			output: Parsed{
+				Name:      "URL",
+				Format:    "trimprefix=https://:upper",
+				Modifiers: []Modifier{{Name: "trimprefix", Arg: "https://", HasArg: true}, {Name: "upper"}},
+			},
+			label: "chain",
+		},
+		{
+			input:  "${NAME:-}",
+			output: Parsed{Name: "NAME", HasFallback: true},
+			label:  "empty-fallback",
+		},
+		{
+			input:  "${USER:-${LOGNAME:-nobody}}",
+			output: Parsed{Name: "USER", HasFallback: true, Fallback: "${LOGNAME:-nobody}"},
+			label:  "nested-fallback",
+		},
+		{
+			input: "${!TARGET:env:secret:trim}",
+			output: Parsed{
+				Name:      "TARGET",
+				Format:    "trim",
+				Modifiers: []Modifier{{Name: "trim"}},
+				Indirect:  true,
+				Env:       true,
+				Secret:    true,
+			},
+			label: "markers",
+		},
+		{
+			input: "${FLAG:seed=USER_ID:coalesce=FLAG_OLD, FLAG_LEGACY:percent}",
+			output: Parsed{
+				Name:         "FLAG",
+				Format:       "percent",
+				Modifiers:    []Modifier{{Name: "percent"}},
+				Seed:         "USER_ID",
+				Alternatives: []string{"FLAG_OLD", "FLAG_LEGACY"},
+			},
+			label: "seed-coalesce",
+		},
+		{
+			input: "${FLAG:seed=:percent}",
+			label: "seed-empty",
+			error: fmt.Errorf("seed needs a variable name like seed=USER"),
+		},
+		{
+			input: "${A:Invalid}",
+			label: "invalid",
+			error: fmt.Errorf("could not parse ${A:Invalid}"),
+		},
+		{
+			input: "prefix ${A}",
+			label: "embedded",
+			error: fmt.Errorf("prefix ${A} is not a single placeholder"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := Explain(testCase.input)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+			assert.Equal(t, testCase.output, output, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+	}
+}
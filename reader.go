@@ -0,0 +1,96 @@
+package expandenv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExpandReaderIndex reads a multi-document YAML stream and expands only the
+// document at index (zero based). All other documents are written to w byte
+// for byte as they were read.
+func ExpandReaderIndex(r io.Reader, w io.Writer, index int, values VariableLookup) error {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	chunks := splitDocuments(string(input))
+
+	current := -1
+	found := false
+	for _, chunk := range chunks {
+		if !chunk.document {
+			continue
+		}
+		current++
+		if current != index {
+			continue
+		}
+		found = true
+
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(chunk.body), &node); err != nil {
+			return fmt.Errorf("document %d is invalid: %w", index, err)
+		}
+		if err := ExpandNode(&node, values, Options{}); err != nil {
+			return err
+		}
+		output := bytes.Buffer{}
+		encoder := yaml.NewEncoder(&output)
+		if err := encoder.Encode(&node); err != nil {
+			return err
+		}
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+		chunk.body = output.String()
+		if !strings.HasSuffix(chunk.separator, "\n") {
+			chunk.separator = strings.TrimRight(chunk.separator, " \t") + "\n"
+		}
+	}
+	if !found {
+		return fmt.Errorf("document %d does not exist", index)
+	}
+
+	for _, chunk := range chunks {
+		if _, err := io.WriteString(w, chunk.separator+chunk.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type documentChunk struct {
+	separator string
+	body      string
+	document  bool
+}
+
+func splitDocuments(input string) []*documentChunk {
+	separatorRegex := regexp.MustCompile(`^---(\s.*)?$`)
+	contentRegex := regexp.MustCompile(`(?m)^\s*[^\s#%]`)
+
+	chunks := []*documentChunk{{}}
+	for _, line := range strings.SplitAfter(input, "\n") {
+		if separatorRegex.MatchString(strings.TrimRight(line, "\r\n")) {
+			// Content after the separator, like --- !tag or --- {a: 1},
+			// belongs to the document. Comments stay with the separator.
+			rest := strings.TrimLeft(line[3:], " \t")
+			if strings.TrimSpace(rest) != "" && !strings.HasPrefix(rest, "#") {
+				chunks = append(chunks, &documentChunk{separator: line[:len(line)-len(rest)], body: rest, document: true})
+				continue
+			}
+			chunks = append(chunks, &documentChunk{separator: line, document: true})
+			continue
+		}
+		chunks[len(chunks)-1].body += line
+	}
+	if contentRegex.MatchString(chunks[0].body) {
+		chunks[0].document = true
+	}
+	return chunks
+}
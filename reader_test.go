@@ -0,0 +1,68 @@
+package expandenv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandReaderIndex(t *testing.T) {
+	values := map[string]string{
+		"READER_A": "a",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+	input := `# leading comment
+first:   ${READER_A}
+---
+second: ${READER_A} # comment
+list:
+  - ${READER_A}
+--- # third
+third:    ${READER_UNKNOWN}
+`
+
+	output := bytes.Buffer{}
+	err := ExpandReaderIndex(strings.NewReader(input), &output, 1, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, `# leading comment
+first:   ${READER_A}
+---
+second: a # comment
+list:
+    - a
+--- # third
+third:    ${READER_UNKNOWN}
+`, output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandReaderIndex(strings.NewReader("# comment\n---\na: ${READER_A}\n"), &output, 0, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "# comment\n---\na: a\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandReaderIndex(strings.NewReader("--- {a: \"${READER_A}\"}\n--- !!map\nb: ${READER_A}\n--- {c: \"${READER_A}\"}\n"), &output, 1, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "--- {a: \"${READER_A}\"}\n---\n!!map\nb: a\n--- {c: \"${READER_A}\"}\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandReaderIndex(strings.NewReader("--- {a: \"${READER_A}\"}\n"), &output, 0, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "---\n{a: \"a\"}\n", output.String())
+
+	output = bytes.Buffer{}
+	err = ExpandReaderIndex(strings.NewReader(input), &output, 2, lookup)
+	assert.EqualError(t, err, "at third: variable READER_UNKNOWN is missing")
+
+	output = bytes.Buffer{}
+	err = ExpandReaderIndex(strings.NewReader(input), &output, 3, lookup)
+	assert.EqualError(t, err, "document 3 does not exist")
+}
@@ -0,0 +1,67 @@
+package expandenv
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Result struct {
+	Output interface{}
+	// Stats holds usage statistics per referenced variable name.
+	Stats map[string]VariableStats
+	// Fallbacks lists every use of a fallback value, sorted by name and value.
+	Fallbacks []FallbackUsage
+	// Placeholders maps the path of every expanded string, like
+	// spec.containers[0].image, to its original text.
+	Placeholders map[string]string
+}
+
+type FallbackUsage struct {
+	Name  string
+	Value string
+}
+
+type VariableStats struct {
+	// References counts every reference, successful or not.
+	References int
+	// Resolved counts the references the lookup found a value for.
+	Resolved int
+	// Fallbacks counts the references that used their fallback value.
+	Fallbacks int
+}
+
+// ExpandWithResult works like ExpandWithOptions but additionally reports
+// details about the expansion.
+func ExpandWithResult(input interface{}, values VariableLookup, options Options) (Result, error) {
+	result := Result{
+		Stats:        map[string]VariableStats{},
+		Placeholders: map[string]string{},
+	}
+	options.placeholders = result.Placeholders
+	observer := options.Observer
+	options.Observer = func(event Event) {
+		stats := result.Stats[event.Name]
+		switch event.Kind {
+		case EventReference:
+			stats.References++
+			result.Stats[event.Name] = stats
+		case EventResolved:
+			stats.Resolved++
+			result.Stats[event.Name] = stats
+		case EventFallback:
+			stats.Fallbacks++
+			result.Stats[event.Name] = stats
+			result.Fallbacks = append(result.Fallbacks, FallbackUsage{Name: event.Name, Value: fmt.Sprintf("%v", event.Value)})
+		}
+		if observer != nil {
+			observer(event)
+		}
+	}
+	output, err := ExpandWithOptions(input, values, options)
+	result.Output = output
+	sort.SliceStable(result.Fallbacks, func(i, j int) bool {
+		a, b := result.Fallbacks[i], result.Fallbacks[j]
+		return a.Name < b.Name || (a.Name == b.Name && a.Value < b.Value)
+	})
+	return result, err
+}
@@ -0,0 +1,91 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandWithResultStats(t *testing.T) {
+	values := map[string]string{
+		"RES_A": "a",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+
+	result, err := ExpandWithResult(map[string]interface{}{
+		"a":        "${RES_A}",
+		"list":     []interface{}{"${RES_A}", "${RES_A} ${RES_A}"},
+		"fallback": "${RES_B:-b} ${RES_B:-b}",
+		"failing":  []interface{}{"${RES_C}", "${RES_A:number}"},
+	}, lookup, Options{})
+	assert.EqualError(t, err, "at failing[0]: variable RES_C is missing, at failing[1]: a is not a valid number")
+	assert.Equal(t, map[string]VariableStats{
+		"RES_A": {References: 5, Resolved: 5},
+		"RES_B": {References: 2, Fallbacks: 2},
+		"RES_C": {References: 1},
+	}, result.Stats)
+}
+
+func TestExpandWithResultFallbacks(t *testing.T) {
+	values := map[string]string{
+		"RES_A": "a",
+	}
+
+	result, err := ExpandWithResult(map[string]interface{}{
+		"a":     "${RES_A:-unused}",
+		"b":     "${RES_B:-b}",
+		"c":     []interface{}{"${RES_C:-}", "${RES_B:-other}"},
+		"plain": "${RES_A}",
+	}, lookupMap(values), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []FallbackUsage{
+		{Name: "RES_B", Value: "b"},
+		{Name: "RES_B", Value: "other"},
+		{Name: "RES_C", Value: ""},
+	}, result.Fallbacks)
+
+	result, err = ExpandWithResult("${RES_A:-unused}", lookupMap(values), Options{})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Fallbacks)
+}
+
+func TestExpandWithResultPlaceholders(t *testing.T) {
+	values := map[string]string{
+		"RES_IMAGE": "app",
+		"RES_TAG":   "1.0",
+		"RES_PORT":  "8080",
+	}
+
+	result, err := ExpandWithResult(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{
+					"image": "${RES_IMAGE}:${RES_TAG}",
+					"port":  "${RES_PORT:number}",
+					"name":  "static",
+				},
+			},
+			"missing": "${RES_UNKNOWN}",
+			"escaped": "\\${RES_TAG}",
+			"nested":  "${RES_UNKNOWN:-${RES_TAG}}",
+		},
+	}, lookupMap(values), Options{})
+	assert.EqualError(t, err, "at spec.missing: variable RES_UNKNOWN is missing")
+	assert.Equal(t, map[string]string{
+		"spec.containers[0].image": "${RES_IMAGE}:${RES_TAG}",
+		"spec.containers[0].port":  "${RES_PORT:number}",
+		"spec.escaped":             "\\${RES_TAG}",
+		"spec.nested":              "${RES_UNKNOWN:-${RES_TAG}}",
+	}, result.Placeholders)
+
+	result, err = ExpandWithResult("${RES_TAG}", lookupMap(values), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"": "${RES_TAG}"}, result.Placeholders)
+}
@@ -0,0 +1,87 @@
+package expandenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaVariable describes one way a variable is referenced.
+type SchemaVariable struct {
+	Name        string  `json:"name"`
+	Format      string  `json:"format,omitempty"`
+	Indirect    bool    `json:"indirect,omitempty"`
+	HasFallback bool    `json:"hasFallback"`
+	Fallback    *string `json:"fallback,omitempty"`
+}
+
+// SchemaJSON describes every variable referenced in input as JSON, e.g. to
+// document the environment a template needs. A variable referenced with
+// different formats or fallbacks is listed once per combination. Markers
+// like env or coalesce=A,B are not part of the format, the variables named
+// by coalesce and seed are listed on their own. Indirect references like
+// ${!REF} are listed under REF with indirect set. Variables are sorted by name.
+func SchemaJSON(input interface{}) ([]byte, error) {
+	variables := []SchemaVariable{}
+	seen := map[string]bool{}
+	errs := []error{}
+	add := func(variable SchemaVariable) {
+		key, _ := json.Marshal(variable)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			variables = append(variables, variable)
+		}
+	}
+	var collect func(str string, path string)
+	collect = func(str string, path string) {
+		for _, pos := range findPlaceholders(str) {
+			placeholder := str[pos[0]:pos[1]]
+			if strings.HasPrefix(placeholder, "\\") {
+				continue
+			}
+			parsed, err := explain(placeholder)
+			if err != nil {
+				errs = append(errs, atPath(path, err))
+				continue
+			}
+			variable := SchemaVariable{Name: parsed.Name, Format: parsed.Format, Indirect: parsed.Indirect, HasFallback: parsed.HasFallback}
+			if parsed.HasFallback {
+				fallback := parsed.Fallback
+				variable.Fallback = &fallback
+				collect(fallback, path)
+			}
+			add(variable)
+			for _, name := range parsed.Alternatives {
+				add(SchemaVariable{Name: name})
+			}
+			if parsed.Seed != "" {
+				add(SchemaVariable{Name: parsed.Seed})
+			}
+		}
+	}
+	var walk func(current interface{}, path string)
+	walk = func(current interface{}, path string) {
+		switch current := current.(type) {
+		case string:
+			collect(current, path)
+		case []interface{}:
+			for i, v := range current {
+				walk(v, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case map[string]interface{}:
+			for _, k := range sortedKeys(current) {
+				collect(k, path)
+				walk(current[k], joinPath(path, k))
+			}
+		}
+	}
+	walk(input, "")
+	if err := joinErrors(errs); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(variables, func(i, j int) bool {
+		return variables[i].Name < variables[j].Name
+	})
+	return json.Marshal(variables)
+}
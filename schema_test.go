@@ -0,0 +1,45 @@
+package expandenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaJSON(t *testing.T) {
+	output, err := SchemaJSON(map[string]interface{}{
+		"host": "${DB_HOST}",
+		"port": "${DB_PORT:number:-5432}",
+		"url":  "${DB_URL:trimprefix=https://:upper}",
+		"list": []interface{}{"${DB_HOST}", "${DB_NAME:-}", "${DB_USER:-${USER}}", "\\${ESCAPED}"},
+		"${DB_KEY}": map[string]interface{}{
+			"static": 42,
+		},
+		"ref":     "${!DB_REF}",
+		"markers": []interface{}{"${DB_PASSWORD:env:secret:trim}", "${DB_REGION:coalesce=AWS_REGION,REGION}", "${DB_CANARY:seed=USER_ID:percent}"},
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"name": "AWS_REGION", "hasFallback": false},
+		{"name": "DB_CANARY", "format": "percent", "hasFallback": false},
+		{"name": "DB_HOST", "hasFallback": false},
+		{"name": "DB_KEY", "hasFallback": false},
+		{"name": "DB_NAME", "hasFallback": true, "fallback": ""},
+		{"name": "DB_PASSWORD", "format": "trim", "hasFallback": false},
+		{"name": "DB_PORT", "format": "number", "hasFallback": true, "fallback": "5432"},
+		{"name": "DB_REF", "indirect": true, "hasFallback": false},
+		{"name": "DB_REGION", "hasFallback": false},
+		{"name": "DB_URL", "format": "trimprefix=https://:upper", "hasFallback": false},
+		{"name": "DB_USER", "hasFallback": true, "fallback": "${USER}"},
+		{"name": "REGION", "hasFallback": false},
+		{"name": "USER", "hasFallback": false},
+		{"name": "USER_ID", "hasFallback": false}
+	]`, string(output))
+
+	output, err = SchemaJSON("static")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[]`, string(output))
+
+	_, err = SchemaJSON(map[string]interface{}{"a": []interface{}{"${A:Invalid}"}})
+	assert.EqualError(t, err, "at a[0]: could not parse ${A:Invalid}")
+}
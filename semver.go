@@ -0,0 +1,17 @@
+package expandenv
+
+import (
+	"regexp"
+	"strings"
+)
+
+var semverRegex = regexp.MustCompile(`^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-((?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// normalizeSemver validates a semantic version and strips a leading v.
+func normalizeSemver(value string) (string, bool) {
+	normalized := strings.TrimPrefix(value, "v")
+	if !semverRegex.MatchString(normalized) {
+		return "", false
+	}
+	return normalized, true
+}
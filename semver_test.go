@@ -0,0 +1,43 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandSemver(t *testing.T) {
+	values := map[string]string{
+		"SEMVER_V":          "v1.2.3",
+		"SEMVER_PLAIN":      "1.2.3",
+		"SEMVER_PRERELEASE": "v1.0.0-rc.1+build.5",
+		"SEMVER_INVALID":    "1.2",
+		"SEMVER_ZERO":       "01.2.3",
+	}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		error  error
+	}{
+		{input: "${SEMVER_V:semver}", output: "1.2.3"},
+		{input: "${SEMVER_PLAIN:semver}", output: "1.2.3"},
+		{input: "${SEMVER_PRERELEASE:semver}", output: "1.0.0-rc.1+build.5"},
+		{input: "image:${SEMVER_V:semver}", output: "image:1.2.3"},
+		{input: "${SEMVER_UNKNOWN:semver:-v2.0.0}", output: "2.0.0"},
+		{input: "${SEMVER_INVALID:semver}", output: "${SEMVER_INVALID:semver}", error: fmt.Errorf("1.2 is not a valid semver")},
+		{input: "${SEMVER_ZERO:semver}", output: "${SEMVER_ZERO:semver}", error: fmt.Errorf("01.2.3 is not a valid semver")},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := Expand(testCase.input, lookupMap(values))
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+}
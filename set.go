@@ -0,0 +1,115 @@
+package expandenv
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxSetIndex bounds list indices of ParseSet to avoid huge allocations.
+const maxSetIndex = 65535
+
+var setKeyRegex = regexp.MustCompile(`^([^.\[\]]+)((?:\[\d+\])*)$`)
+
+type setSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// ParseSet builds a nested structure from --set style pairs like
+// a.b.c=value or list[0].name=value. Values true, false and null as well as
+// integers are converted to their type, everything else is kept as string.
+// Later pairs override earlier ones.
+func ParseSet(pairs []string) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("pair %s is missing a =", pair)
+		}
+		segments, err := parseSetKey(key)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := setValue(result, segments, inferSetValue(value))
+		if err != nil {
+			return nil, fmt.Errorf("key %s %w", key, err)
+		}
+		result = updated.(map[string]interface{})
+	}
+	return result, nil
+}
+
+func parseSetKey(key string) ([]setSegment, error) {
+	segments := []setSegment{}
+	for _, part := range strings.Split(key, ".") {
+		m := setKeyRegex.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("key %s is invalid", key)
+		}
+		segments = append(segments, setSegment{key: m[1]})
+		for _, index := range strings.Split(strings.Trim(m[2], "[]"), "][") {
+			if index == "" {
+				continue
+			}
+			i, err := strconv.Atoi(index)
+			if err != nil || i > maxSetIndex {
+				return nil, fmt.Errorf("index %s of key %s is too large", index, key)
+			}
+			segments = append(segments, setSegment{index: i, isIndex: true})
+		}
+	}
+	return segments, nil
+}
+
+func setValue(current interface{}, segments []setSegment, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	segment := segments[0]
+	if segment.isIndex {
+		list, ok := current.([]interface{})
+		if !ok && current != nil {
+			return nil, fmt.Errorf("conflicts with a value that is not a list")
+		}
+		for len(list) <= segment.index {
+			list = append(list, nil)
+		}
+		child, err := setValue(list[segment.index], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		list[segment.index] = child
+		return list, nil
+	}
+	m, ok := current.(map[string]interface{})
+	if !ok && current != nil {
+		return nil, fmt.Errorf("conflicts with a value that is not a map")
+	}
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	child, err := setValue(m[segment.key], segments[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	m[segment.key] = child
+	return m, nil
+}
+
+func inferSetValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if i, err := strconv.Atoi(value); err == nil && strconv.Itoa(i) == value {
+		return i
+	}
+	return value
+}
@@ -0,0 +1,81 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSet(t *testing.T) {
+	testCases := []struct {
+		input  []string
+		output map[string]interface{}
+		error  error
+	}{
+		{
+			input: []string{"a.b.c=value", "a.b.d=42", "a.e=true", "f=null", "g=007", "h=1.5", "i=x=y"},
+			output: map[string]interface{}{
+				"a": map[string]interface{}{
+					"b": map[string]interface{}{"c": "value", "d": 42},
+					"e": true,
+				},
+				"f": nil,
+				"g": "007",
+				"h": "1.5",
+				"i": "x=y",
+			},
+		},
+		{
+			input: []string{"list[1]=b", "list[0]=a", "servers[0].host=${HOST}", "servers[0].ports[1]=443", "matrix[0][1]=x"},
+			output: map[string]interface{}{
+				"list": []interface{}{"a", "b"},
+				"servers": []interface{}{
+					map[string]interface{}{"host": "${HOST}", "ports": []interface{}{nil, 443}},
+				},
+				"matrix": []interface{}{[]interface{}{nil, "x"}},
+			},
+		},
+		{
+			input:  []string{"a=1", "a=2"},
+			output: map[string]interface{}{"a": 2},
+		},
+		{
+			input: []string{"a=1", "a.b=2"},
+			error: fmt.Errorf("key a.b conflicts with a value that is not a map"),
+		},
+		{
+			input: []string{"a.b=1", "a[0]=2"},
+			error: fmt.Errorf("key a[0] conflicts with a value that is not a list"),
+		},
+		{
+			input: []string{"a"},
+			error: fmt.Errorf("pair a is missing a ="),
+		},
+		{
+			input: []string{"a..b=1"},
+			error: fmt.Errorf("key a..b is invalid"),
+		},
+		{
+			input: []string{"a[99999999]=1"},
+			error: fmt.Errorf("index 99999999 of key a[99999999] is too large"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := ParseSet(testCase.input)
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+			assert.Equal(t, testCase.output, output, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+	}
+
+	values, err := ParseSet([]string{"servers[0].host=${SET_HOST}"})
+	assert.NoError(t, err)
+	output, err := ExpandMap(values, map[string]string{"SET_HOST": "db.local"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"servers": []interface{}{map[string]interface{}{"host": "db.local"}}}, output)
+}
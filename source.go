@@ -0,0 +1,72 @@
+package expandenv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SourceSet resolves variables from multiple named sources. The source with
+// the highest priority that knows a variable wins, sources of equal priority
+// are asked in the order they were added. A source reporting a variable
+// as missing with a *MissingError is skipped, any other error is returned.
+// Lookups may run concurrently, adding sources may not.
+type SourceSet struct {
+	sources    []source
+	mutex      sync.Mutex
+	provenance map[string]string
+}
+
+type source struct {
+	name     string
+	priority int
+	lookup   VariableLookup
+}
+
+// NewSourceSet returns an empty SourceSet.
+func NewSourceSet() *SourceSet {
+	return &SourceSet{
+		provenance: map[string]string{},
+	}
+}
+
+// Add registers a source under the given name.
+func (s *SourceSet) Add(name string, priority int, lookup VariableLookup) {
+	s.sources = append(s.sources, source{name: name, priority: priority, lookup: lookup})
+	sort.SliceStable(s.sources, func(i, j int) bool {
+		return s.sources[i].priority > s.sources[j].priority
+	})
+}
+
+// Lookup is a VariableLookup that asks the registered sources and records
+// which one supplied the value.
+func (s *SourceSet) Lookup(key string) (*string, error) {
+	for _, source := range s.sources {
+		value, err := source.lookup(key)
+		var missingErr *MissingError
+		if errors.As(err, &missingErr) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source.name, err)
+		}
+		s.mutex.Lock()
+		s.provenance[key] = source.name
+		s.mutex.Unlock()
+		return value, nil
+	}
+	return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+}
+
+// Provenance returns the name of the source that supplied each variable
+// looked up so far.
+func (s *SourceSet) Provenance() map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make(map[string]string, len(s.provenance))
+	for key, name := range s.provenance {
+		result[key] = name
+	}
+	return result
+}
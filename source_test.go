@@ -0,0 +1,60 @@
+package expandenv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceSet(t *testing.T) {
+	sources := NewSourceSet()
+	sources.Add("env", 10, lookupMap(map[string]string{
+		"SOURCE_HOST": "env.local",
+		"SOURCE_PORT": "8080",
+	}))
+	sources.Add("flags", 20, lookupMap(map[string]string{
+		"SOURCE_HOST": "flags.local",
+	}))
+	sources.Add("file", 10, lookupMap(map[string]string{
+		"SOURCE_PORT": "9090",
+		"SOURCE_USER": "admin",
+	}))
+
+	output, err := Expand("${SOURCE_USER}@${SOURCE_HOST}:${SOURCE_PORT}/${SOURCE_DB:-app}", sources.Lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@flags.local:8080/app", output)
+	assert.Equal(t, map[string]string{
+		"SOURCE_HOST": "flags",
+		"SOURCE_PORT": "env",
+		"SOURCE_USER": "file",
+	}, sources.Provenance())
+
+	_, err = Expand("${SOURCE_DB}", sources.Lookup)
+	assert.EqualError(t, err, "variable SOURCE_DB is missing")
+
+	sources.Add("remote", 30, func(key string) (*string, error) {
+		if key == "SOURCE_TOKEN" {
+			return nil, errors.New("connection refused")
+		}
+		return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+	})
+	_, err = Expand("${SOURCE_TOKEN}", sources.Lookup)
+	assert.EqualError(t, err, "source remote: connection refused")
+	output, err = Expand("${SOURCE_HOST}", sources.Lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "flags.local", output)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = sources.Lookup("SOURCE_PORT")
+			_ = sources.Provenance()
+		}()
+	}
+	wg.Wait()
+}
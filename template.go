@@ -0,0 +1,78 @@
+package expandenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExpandTemplateFile loads a YAML file, resolves its include directives and
+// expands the result. An include directive is a line of the form
+//
+//	#include other.yaml
+//
+// with the path relative to the including file. Included documents are
+// merged in order, the including document itself is merged last and thus
+// takes precedence.
+func ExpandTemplateFile(path string, values VariableLookup) (interface{}, error) {
+	input, err := loadTemplateFile(path, []string{})
+	if err != nil {
+		return nil, err
+	}
+	return Expand(input, values)
+}
+
+func loadTemplateFile(path string, stack []string) (interface{}, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range stack {
+		if p == path {
+			return nil, fmt.Errorf("include cycle detected: %s", strings.Join(append(stack[i:], path), " -> "))
+		}
+	}
+	stack = append(stack, path)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	includeRegex := regexp.MustCompile(`(?m)^#include\s+(.+?)\s*$`)
+	for _, match := range includeRegex.FindAllStringSubmatch(string(content), -1) {
+		included, err := loadTemplateFile(filepath.Join(filepath.Dir(path), match[1]), stack)
+		if err != nil {
+			return nil, err
+		}
+		result = mergeValues(result, included)
+	}
+	var own interface{}
+	if err := yaml.Unmarshal(content, &own); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %w", path, err)
+	}
+	return mergeValues(result, own), nil
+}
+
+func mergeValues(base interface{}, override interface{}) interface{} {
+	if override == nil {
+		return base
+	}
+	baseMap, ok1 := base.(map[string]interface{})
+	overrideMap, ok2 := override.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return override
+	}
+	result := map[string]interface{}{}
+	for k, v := range baseMap {
+		result[k] = v
+	}
+	for k, v := range overrideMap {
+		result[k] = mergeValues(result[k], v)
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package expandenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandTemplateFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.yaml": `#include base/defaults.yaml
+name: ${TPL_NAME}
+database:
+  host: ${TPL_HOST}
+`,
+		"base/defaults.yaml": `#include ../common.yaml
+database:
+  host: localhost
+  port: 5432
+`,
+		"common.yaml": `labels:
+  - ${TPL_NAME}
+`,
+		"cycle-a.yaml": "#include cycle-b.yaml\na: 1\n",
+		"cycle-b.yaml": "#include cycle-a.yaml\nb: 1\n",
+	}
+	for name, content := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		assert.NoError(t, err)
+	}
+	values := map[string]string{
+		"TPL_NAME": "app",
+		"TPL_HOST": "db.example.com",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+
+	output, err := ExpandTemplateFile(filepath.Join(dir, "main.yaml"), lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":   "app",
+		"labels": []interface{}{"app"},
+		"database": map[string]interface{}{
+			"host": "db.example.com",
+			"port": 5432,
+		},
+	}, output)
+
+	_, err = ExpandTemplateFile(filepath.Join(dir, "cycle-a.yaml"), lookup)
+	assert.EqualError(t, err, fmt.Sprintf("include cycle detected: %s -> %s -> %s",
+		filepath.Join(dir, "cycle-a.yaml"), filepath.Join(dir, "cycle-b.yaml"), filepath.Join(dir, "cycle-a.yaml")))
+}
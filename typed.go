@@ -0,0 +1,45 @@
+package expandenv
+
+import (
+	"fmt"
+)
+
+// TypedLookup is like VariableLookup, but may return values of any type. A
+// lookup that does not find a variable returns false.
+type TypedLookup = func(key string) (interface{}, bool, error)
+
+// ExpandTyped expands with a lookup returning typed values. A placeholder
+// making up a whole string without a format, like ${PORT}, is replaced by
+// the value as is. Otherwise the value is used in its string form, so
+// ${PORT:number} and port-${PORT} work as usual. A nil value leaves the
+// placeholder untouched.
+func ExpandTyped(input interface{}, values TypedLookup) (interface{}, error) {
+	typed := map[string]interface{}{}
+	lookup := func(key string) (*string, error) {
+		value, found, err := values(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, &MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		if value == nil {
+			return nil, nil
+		}
+		typed[key] = value
+		str := stringify(value)
+		return &str, nil
+	}
+	return ExpandWithOptions(input, lookup, Options{typedValues: typed})
+}
+
+// ExpandMapInterface expands with values of mixed types, e.g. from a decoded
+// config file. The typing rules of ExpandTyped apply: ${PORT} yields the
+// value with its original type, while port-${PORT} or ${PORT:string} use
+// its string form.
+func ExpandMapInterface(input interface{}, values map[string]interface{}) (interface{}, error) {
+	return ExpandTyped(input, func(key string) (interface{}, bool, error) {
+		value, ok := values[key]
+		return value, ok, nil
+	})
+}
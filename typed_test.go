@@ -0,0 +1,81 @@
+package expandenv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandTyped(t *testing.T) {
+	values := map[string]interface{}{
+		"TYPED_PORT":  8080,
+		"TYPED_RATIO": 0.5,
+		"TYPED_HOSTS": []interface{}{"a", "b"},
+		"TYPED_NAME":  "app",
+		"TYPED_NIL":   nil,
+	}
+	lookup := func(key string) (interface{}, bool, error) {
+		value, ok := values[key]
+		return value, ok, nil
+	}
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		error  error
+	}{
+		{input: "${TYPED_PORT}", output: 8080},
+		{input: "${TYPED_RATIO}", output: 0.5},
+		{input: "${TYPED_HOSTS}", output: []interface{}{"a", "b"}},
+		{input: "${TYPED_NAME}", output: "app"},
+		{input: "${TYPED_PORT:string}", output: "8080"},
+		{input: "${TYPED_RATIO:number}", output: 0.5},
+		{input: "port-${TYPED_PORT}", output: "port-8080"},
+		{input: "hosts=${TYPED_HOSTS}", output: `hosts=["a","b"]`},
+		{input: map[string]interface{}{"port": "${TYPED_PORT}"}, output: map[string]interface{}{"port": 8080}},
+		{input: "${TYPED_UNKNOWN:-80}", output: "80"},
+		{input: "${TYPED_NIL}", output: "${TYPED_NIL}"},
+		{input: "${TYPED_UNKNOWN}", output: "${TYPED_UNKNOWN}", error: fmt.Errorf("variable TYPED_UNKNOWN is missing")},
+	}
+
+	for _, testCase := range testCases {
+		label := fmt.Sprintf("%v", testCase.input)
+		output, err := ExpandTyped(testCase.input, lookup)
+		if testCase.error == nil {
+			assert.NoError(t, err, label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), label)
+		}
+		assert.Equal(t, testCase.output, output, label)
+	}
+}
+
+func TestExpandMapInterface(t *testing.T) {
+	values := map[string]interface{}{
+		"IFACE_NAME":    "app",
+		"IFACE_PORT":    8080,
+		"IFACE_ENABLED": true,
+	}
+
+	output, err := ExpandMapInterface(map[string]interface{}{
+		"name":    "${IFACE_NAME}",
+		"port":    "${IFACE_PORT}",
+		"enabled": "${IFACE_ENABLED}",
+		"address": "${IFACE_NAME}:${IFACE_PORT}",
+		"flag":    "--enabled=${IFACE_ENABLED}",
+		"text":    "${IFACE_PORT:string}",
+	}, values)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":    "app",
+		"port":    8080,
+		"enabled": true,
+		"address": "app:8080",
+		"flag":    "--enabled=true",
+		"text":    "8080",
+	}, output)
+
+	_, err = ExpandMapInterface("${IFACE_UNKNOWN}", values)
+	assert.EqualError(t, err, "variable IFACE_UNKNOWN is missing")
+}
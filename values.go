@@ -0,0 +1,37 @@
+package expandenv
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExpandFromValuesFile loads a flat YAML map of values, expands them against
+// the process environment and then uses them to expand input.
+func ExpandFromValuesFile(input interface{}, valuesPath string) (interface{}, error) {
+	content, err := os.ReadFile(valuesPath)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %w", valuesPath, err)
+	}
+	expanded, err := ExpandEnv(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", valuesPath, err)
+	}
+	values := map[string]string{}
+	for k, v := range expanded.(map[string]interface{}) {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s: value %s must be a scalar", valuesPath, k)
+		case nil:
+			values[k] = ""
+		default:
+			values[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return ExpandMap(input, values)
+}
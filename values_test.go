@@ -0,0 +1,42 @@
+package expandenv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandFromValuesFile(t *testing.T) {
+	t.Setenv("VALUES_ENV_HOST", "db.example.com")
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "values.yaml"), []byte(`host: ${VALUES_ENV_HOST}
+port: 5432
+name: app
+`), 0o644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "nested.yaml"), []byte(`nested:
+  key: value
+`), 0o644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "missing.yaml"), []byte(`host: ${VALUES_ENV_UNKNOWN}
+`), 0o644)
+	assert.NoError(t, err)
+
+	output, err := ExpandFromValuesFile(map[string]interface{}{
+		"url":  "postgres://${host}:${port}/${name}",
+		"port": "${port:number}",
+	}, filepath.Join(dir, "values.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"url":  "postgres://db.example.com:5432/app",
+		"port": 5432,
+	}, output)
+
+	_, err = ExpandFromValuesFile("${host}", filepath.Join(dir, "nested.yaml"))
+	assert.EqualError(t, err, filepath.Join(dir, "nested.yaml")+": value nested must be a scalar")
+
+	_, err = ExpandFromValuesFile("${host}", filepath.Join(dir, "missing.yaml"))
+	assert.EqualError(t, err, filepath.Join(dir, "missing.yaml")+": at host: environment variable VALUES_ENV_UNKNOWN is missing")
+}
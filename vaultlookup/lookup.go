@@ -0,0 +1,66 @@
+// Package vaultlookup resolves variables from the KV secrets engine of
+// HashiCorp Vault. It does not depend on the Vault client itself, callers
+// pass a small adapter instead:
+//
+//	type adapter struct{ client *vault.Client }
+//
+//	func (a adapter) ReadSecret(ctx context.Context, mountPath string, path string) (map[string]interface{}, bool, error) {
+//		secret, err := a.client.KVv2(mountPath).Get(ctx, path)
+//		if errors.Is(err, vault.ErrSecretNotFound) {
+//			return nil, false, nil
+//		}
+//		if err != nil {
+//			return nil, false, err
+//		}
+//		return secret.Data, true, nil
+//	}
+package vaultlookup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/airfocusio/go-expandenv"
+)
+
+// DefaultField is the field used if a name has no #field selector.
+const DefaultField = "value"
+
+type Client interface {
+	ReadSecret(ctx context.Context, mountPath string, path string) (data map[string]interface{}, found bool, err error)
+}
+
+// NewLookup resolves a variable like path/to/secret#field from the field of
+// the secret stored at the given path below mountPath. Without a selector,
+// the field DefaultField is used. Values that are not strings are encoded as
+// JSON.
+func NewLookup(client Client, mountPath string) expandenv.VariableLookup {
+	return func(key string) (*string, error) {
+		path, field, found := strings.Cut(key, "#")
+		if !found {
+			field = DefaultField
+		}
+		data, found, err := client.ReadSecret(context.Background(), mountPath, path)
+		if err != nil {
+			return nil, fmt.Errorf("vault lookup of %s failed: %w", path, err)
+		}
+		if !found {
+			return nil, &expandenv.MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		raw, ok := data[field]
+		if !ok {
+			return nil, &expandenv.MissingError{Name: key, Err: fmt.Errorf("variable %s is missing", key)}
+		}
+		if value, ok := raw.(string); ok {
+			return &value, nil
+		}
+		encoded, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("vault lookup of %s failed: %w", path, err)
+		}
+		value := string(encoded)
+		return &value, nil
+	}
+}
@@ -0,0 +1,92 @@
+package vaultlookup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/airfocusio/go-expandenv"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockClient map[string]map[string]interface{}
+
+func (c mockClient) ReadSecret(ctx context.Context, mountPath string, path string) (map[string]interface{}, bool, error) {
+	if path == "broken" {
+		return nil, false, fmt.Errorf("permission denied")
+	}
+	data, ok := c[mountPath+"/"+path]
+	return data, ok, nil
+}
+
+func TestNewLookup(t *testing.T) {
+	lookup := NewLookup(mockClient{
+		"secret/app/db": {
+			"username": "admin",
+			"password": "s3cr3t",
+			"port":     5432,
+		},
+		"secret/app/token": {
+			"value": "abc",
+		},
+		"other/app/db": {
+			"username": "other",
+		},
+	}, "secret")
+
+	testCases := []struct {
+		input  interface{}
+		output interface{}
+		label  string
+		error  error
+	}{
+		{
+			input:  "${app/db#username}:${app/db#password}",
+			output: "admin:s3cr3t",
+			label:  "field",
+		},
+		{
+			input:  "${app/db#port:number}",
+			output: 5432,
+			label:  "field-non-string",
+		},
+		{
+			input:  "${app/token}",
+			output: "abc",
+			label:  "default-field",
+		},
+		{
+			input:  "${app/unknown#username}",
+			output: "${app/unknown#username}",
+			label:  "miss-secret",
+			error:  fmt.Errorf("variable app/unknown#username is missing"),
+		},
+		{
+			input:  "${app/db#unknown}",
+			output: "${app/db#unknown}",
+			label:  "miss-field",
+			error:  fmt.Errorf("variable app/db#unknown is missing"),
+		},
+		{
+			input:  "${app/db:-fallback}",
+			output: "fallback",
+			label:  "miss-default-field-fallback",
+		},
+		{
+			input:  "${broken#field}",
+			output: "${broken#field}",
+			label:  "transport-error",
+			error:  fmt.Errorf("vault lookup of broken failed: permission denied"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := expandenv.Expand(testCase.input, lookup)
+		if testCase.error == nil {
+			assert.NoError(t, err, testCase.label)
+		} else {
+			assert.EqualError(t, err, testCase.error.Error(), testCase.label)
+		}
+		assert.Equal(t, testCase.output, output, testCase.label)
+	}
+}
@@ -0,0 +1,29 @@
+package expandenv
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// ExpandBytes expands a YAML document.
+func ExpandBytes(input []byte, values VariableLookup) ([]byte, error) {
+	return ExpandBytesWithOptions(input, values, Options{})
+}
+
+// ExpandString expands a YAML document given as string.
+func ExpandString(input string, values VariableLookup) (string, error) {
+	output, err := ExpandBytes([]byte(input), values)
+	return string(output), err
+}
+
+func ExpandBytesWithOptions(input []byte, values VariableLookup, options Options) ([]byte, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(input, &raw); err != nil {
+		return nil, err
+	}
+	raw, expandErr := ExpandWithOptions(raw, values, options)
+	output, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return output, expandErr
+}
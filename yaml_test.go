@@ -0,0 +1,196 @@
+package expandenv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandBytesFloatFormat(t *testing.T) {
+	values := map[string]string{
+		"FLOAT_LARGE": "1e20",
+		"FLOAT_SMALL": "42.5",
+	}
+	lookup := func(key string) (*string, error) {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is missing", key)
+		}
+		return &value, nil
+	}
+	input := []byte("large: ${FLOAT_LARGE:number}\nsmall: ${FLOAT_SMALL:number}\nembedded: x${FLOAT_LARGE:number}\n")
+
+	output, err := ExpandBytes(input, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "embedded: x1e+20\nlarge: 1e+20\nsmall: 42.5\n", string(output))
+
+	output, err = ExpandBytesWithOptions(input, lookup, Options{FloatFormat: FloatFormat{Format: 'f', Precision: -1}})
+	assert.NoError(t, err)
+	assert.Equal(t, "embedded: x100000000000000000000\nlarge: 100000000000000000000\nsmall: 42.5\n", string(output))
+
+	output, err = ExpandBytesWithOptions(input, lookup, Options{FloatFormat: FloatFormat{Format: 'f', Precision: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, "embedded: x100000000000000000000.00\nlarge: 100000000000000000000.00\nsmall: 42.50\n", string(output))
+
+	expanded, err := ExpandWithOptions(map[string]interface{}{"large": "${FLOAT_LARGE:number}"}, lookup, Options{FloatFormat: FloatFormat{Format: 'f', Precision: -1}})
+	assert.NoError(t, err)
+	jsonBytes, err := json.Marshal(expanded)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"large":100000000000000000000}`, string(jsonBytes))
+
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err = json.Marshal(FormattedFloat{Value: value, Text: strconv.FormatFloat(value, 'f', -1, 64)})
+		assert.Error(t, err)
+	}
+}
+
+func TestExpandBytesEmptyFallbacks(t *testing.T) {
+	output, err := ExpandBytes([]byte("list: ${EMPTY_LIST:-[]}\nmap: ${EMPTY_MAP:-{}}\nstring: ${EMPTY_STRING:string:-[]}\n"), lookupMap(map[string]string{}))
+	assert.NoError(t, err)
+	assert.Equal(t, "list: []\nmap: {}\nstring: '[]'\n", string(output))
+}
+
+type testEndpoint struct {
+	Host string
+	Port int
+}
+
+func (e testEndpoint) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("%s:%d", e.Host, e.Port), nil
+}
+
+func (e *testEndpoint) UnmarshalYAML(value *yaml.Node) error {
+	host, port, ok := strings.Cut(value.Value, ":")
+	if !ok {
+		return fmt.Errorf("%s is not a valid endpoint", value.Value)
+	}
+	e.Host = host
+	_, err := fmt.Sscanf(port, "%d", &e.Port)
+	return err
+}
+
+func TestExpandYAMLMarshalers(t *testing.T) {
+	values := map[string]string{
+		"MARSHALER_HOST": "db.example.com",
+	}
+	input := map[string]interface{}{
+		"placeholder": testEndpoint{Host: "${MARSHALER_HOST}", Port: 5432},
+		"static":      testEndpoint{Host: "localhost", Port: 80},
+	}
+
+	output, err := ExpandMap(input, values)
+	assert.NoError(t, err)
+	assert.Equal(t, input, output)
+
+	output, err = ExpandWithOptions(input, lookupMap(values), Options{ExpandYAMLMarshalers: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"placeholder": testEndpoint{Host: "db.example.com", Port: 5432},
+		"static":      testEndpoint{Host: "localhost", Port: 80},
+	}, output)
+
+	output, err = ExpandWithOptions(testEndpoint{Host: "${MARSHALER_UNKNOWN}", Port: 1}, lookupMap(values), Options{ExpandYAMLMarshalers: true})
+	assert.EqualError(t, err, "variable MARSHALER_UNKNOWN is missing")
+	assert.Equal(t, testEndpoint{Host: "${MARSHALER_UNKNOWN}", Port: 1}, output)
+}
+
+func TestExpandString(t *testing.T) {
+	values := map[string]string{
+		"MAP_A":          "a",
+		"MAP_MULTI_LINE": "line1\nline2",
+	}
+
+	output, err := ExpandString(`
+a: ${MAP_A}
+b: prefix ${MAP_A} suffix
+c:
+    - ${MAP_A}
+    - ${MAP_A}
+d: ${MAP_MULTI_LINE}
+e: ${MAP_UNKNOWN}
+f: \${MAP_ESCAPED}
+g: \\${MAP_ESCAPED}
+`, lookupMap(values))
+	assert.EqualError(t, err, "at e: variable MAP_UNKNOWN is missing")
+	assert.Equal(t, `a: a
+b: prefix a suffix
+c:
+    - a
+    - a
+d: |-
+    line1
+    line2
+e: ${MAP_UNKNOWN}
+f: ${MAP_ESCAPED}
+g: \${MAP_ESCAPED}
+`, output)
+}
+
+func TestExpandBytesEmbeddedJSON(t *testing.T) {
+	values := map[string]string{
+		"EMBEDDED_HOST": "db.local",
+		"EMBEDDED_PORT": "5432",
+	}
+	input := []byte(`data:
+  config.json: '{"host": "${EMBEDDED_HOST}", "port": "${EMBEDDED_PORT:number}", "tags": ["a"]}'
+  static.json: '{ "kept": "as written" }'
+  text: '{not json ${EMBEDDED_HOST}}'
+`)
+
+	output, err := ExpandBytesWithOptions(input, lookupMap(values), Options{ExpandEmbeddedJSON: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `data:
+    config.json: '{"host":"db.local","port":5432,"tags":["a"]}'
+    static.json: '{ "kept": "as written" }'
+    text: '{not json db.local}'
+`, string(output))
+
+	_, err = ExpandBytesWithOptions([]byte(`config.json: '{"host": "${EMBEDDED_UNKNOWN}"}'`), lookupMap(values), Options{ExpandEmbeddedJSON: true})
+	assert.EqualError(t, err, "at config.json.host: variable EMBEDDED_UNKNOWN is missing")
+}
+
+func TestExpandTimestampsAndBinary(t *testing.T) {
+	values := map[string]string{
+		"BINARY_HOST": "db.local",
+		"BINARY_PORT": "5432",
+	}
+	text := base64.StdEncoding.EncodeToString([]byte("host=${BINARY_HOST}"))
+	input := []byte("created: 2001-12-14T21:59:43.10-05:00\ntext: !!binary " + text + "\n")
+	var raw interface{}
+	err := yaml.Unmarshal(input, &raw)
+	assert.NoError(t, err)
+	created := raw.(map[string]interface{})["created"]
+	assert.IsType(t, time.Time{}, created)
+
+	output, err := ExpandWithOptions(raw, lookupMap(values), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"created": created,
+		"text":    "host=db.local",
+	}, output)
+
+	binary := map[string]interface{}{
+		"text": []byte("host=${BINARY_HOST}"),
+		"port": []byte("${BINARY_PORT:number}"),
+		"blob": []byte{0xff, 0x00, 0xfe},
+	}
+	output, err = ExpandWithOptions(binary, lookupMap(values), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, binary, output)
+
+	output, err = ExpandWithOptions(binary, lookupMap(values), Options{ExpandBinaryText: true})
+	assert.EqualError(t, err, "at port: ${BINARY_PORT:number} does not expand to a string")
+	assert.Equal(t, map[string]interface{}{
+		"text": []byte("host=db.local"),
+		"port": []byte("${BINARY_PORT:number}"),
+		"blob": []byte{0xff, 0x00, 0xfe},
+	}, output)
+}